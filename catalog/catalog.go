@@ -0,0 +1,249 @@
+// Package catalog records the databases the manager has provisioned in an
+// embedded bbolt file, so that the service knows what it created, for whom
+// and with which users, independently of the database servers themselves.
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrNotFound = errors.New("database is not in the catalog")
+
+var databasesBucket = []byte("databases")
+
+type Status string
+
+const (
+	StatusActive Status = "active"
+	// StatusRecycled databases were deleted but still exist on the server
+	// under another name until they are purged.
+	StatusRecycled Status = "recycled"
+	StatusDeleted  Status = "deleted"
+)
+
+// Record describes one managed database.
+type Record struct {
+	Engine string `json:"engine"`
+	Target string `json:"target"`
+	Name   string `json:"name"`
+	// Owner is who the database was created for, as given by the caller.
+	Owner string `json:"owner,omitempty"`
+	// Users are the database users the manager generated for it.
+	Users  []string          `json:"users"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// DeletionProtection makes deletes and renames of the database fail
+	// until it is cleared.
+	DeletionProtection bool       `json:"deletion_protection,omitempty"`
+	Status             Status     `json:"status"`
+	LastOperation      string     `json:"last_operation"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+	DeletedAt          *time.Time `json:"deleted_at,omitempty"`
+	// OriginalName is the name a recycled database had before it was
+	// deleted, and PurgeAt when it is dropped for good.
+	OriginalName string     `json:"original_name,omitempty"`
+	PurgeAt      *time.Time `json:"purge_at,omitempty"`
+	// Rotations are the credential rotations of the database's users
+	// whose old credential has not been retired yet.
+	Rotations []Rotation `json:"rotations,omitempty"`
+}
+
+// Rotation is a credential rotation in its grace period, during which both
+// the old and the new credential of Username work.
+type Rotation struct {
+	Username string `json:"username"`
+	// NewUsername logs in with the new credential and RetiredUsername with
+	// the old one; either may equal Username.
+	NewUsername     string `json:"new_username"`
+	RetiredUsername string `json:"retired_username"`
+	// ReplacesUser means NewUsername takes the place of RetiredUsername
+	// among the database's users once the rotation finishes.
+	ReplacesUser bool      `json:"replaces_user,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+	// RetireAt is when the old credential is retired; without it the
+	// rotation waits to be confirmed.
+	RetireAt *time.Time `json:"retire_at,omitempty"`
+}
+
+func (r *Record) key() []byte {
+	return Key(r.Engine, r.Target, r.Name)
+}
+
+// Key is the catalog key of a database: "engine/target/name".
+func Key(engine, target, name string) []byte {
+	return []byte(engine + "/" + target + "/" + name)
+}
+
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the catalog file at path.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening catalog: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(databasesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing catalog: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// DB returns the underlying bbolt database, for other state that is kept
+// in the same file.
+func (s *Store) DB() *bolt.DB {
+	return s.db
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Put stores r, replacing any record with the same key.
+func (s *Store) Put(r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(databasesBucket).Put(r.key(), data)
+	})
+}
+
+func (s *Store) Get(engine, target, name string) (*Record, error) {
+	var record *Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(databasesBucket).Get(Key(engine, target, name))
+		if data == nil {
+			return ErrNotFound
+		}
+		record = &Record{}
+		return json.Unmarshal(data, record)
+	})
+	return record, err
+}
+
+// Update applies fn to the stored record in a single transaction. The
+// record is created when it does not exist yet, so fn must fill in
+// anything a new record needs.
+func (s *Store) Update(engine, target, name string, fn func(r *Record) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(databasesBucket)
+		key := Key(engine, target, name)
+
+		record := &Record{Engine: engine, Target: target, Name: name}
+		if data := bucket.Get(key); data != nil {
+			if err := json.Unmarshal(data, record); err != nil {
+				return err
+			}
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, data)
+	})
+}
+
+// Rename moves a record to a new database name, keeping its history.
+func (s *Store) Rename(engine, target, oldName, newName string, fn func(r *Record)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(databasesBucket)
+		oldKey := Key(engine, target, oldName)
+
+		record := &Record{Engine: engine, Target: target}
+		if data := bucket.Get(oldKey); data != nil {
+			if err := json.Unmarshal(data, record); err != nil {
+				return err
+			}
+			if err := bucket.Delete(oldKey); err != nil {
+				return err
+			}
+		}
+		record.Name = newName
+		fn(record)
+
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(record.key(), data)
+	})
+}
+
+// Filter selects records in List. Empty fields match everything.
+type Filter struct {
+	Engine string
+	Target string
+	Prefix string
+	Status Status
+	Owner  string
+	Labels map[string]string
+}
+
+// Matches reports whether r is selected by f.
+func (f Filter) Matches(r *Record) bool {
+	if f.Engine != "" && r.Engine != f.Engine {
+		return false
+	}
+	if f.Target != "" && r.Target != f.Target {
+		return false
+	}
+	if f.Prefix != "" && !strings.HasPrefix(r.Name, f.Prefix) {
+		return false
+	}
+	if f.Status != "" && r.Status != f.Status {
+		return false
+	}
+	if f.Owner != "" && r.Owner != f.Owner && !contains(r.Users, f.Owner) {
+		return false
+	}
+	for key, value := range f.Labels {
+		if r.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// List returns the records matching filter in key order.
+func (s *Store) List(filter Filter) ([]Record, error) {
+	var records []Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(databasesBucket).ForEach(func(_, data []byte) error {
+			var record Record
+			if err := json.Unmarshal(data, &record); err != nil {
+				return err
+			}
+			if filter.Matches(&record) {
+				records = append(records, record)
+			}
+			return nil
+		})
+	})
+	return records, err
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bonheur15/go-db-manager/database"
+	"github.com/bonheur15/go-db-manager/manager"
+	"github.com/gofor-little/env"
+	"github.com/rs/zerolog/log"
+)
+
+type Config struct {
+	APIKey            string
+	Targets           []database.TargetConfig
+	HealthCheckPeriod time.Duration
+	Placement         manager.PlacementPolicy
+	CatalogPath       string
+	EncryptionKey     []byte
+	IdempotencyWindow time.Duration
+	JobConcurrency    int
+	// JobRetention is how long finished jobs are kept; zero keeps them.
+	JobRetention time.Duration
+	// LockBackend is "local", "postgres" or "mysql"; the advisory backends
+	// take their locks on the server of LockTarget.
+	LockBackend string
+	LockTarget  string
+	LockWait    time.Duration
+	// RecycleRetention is how long deleted databases stay restorable; zero
+	// makes deletes permanent.
+	RecycleRetention time.Duration
+	// RotationGrace is how long the old credential of a rotation keeps
+	// working by default; zero waits for the rotation to be confirmed.
+	RotationGrace time.Duration
+	// ConfirmEngines lists the engines ("*" for all) whose renames and
+	// deletes need a confirmation token, valid for ConfirmationTTL.
+	ConfirmEngines  []string
+	ConfirmationTTL time.Duration
+	Names           manager.NamePolicy
+}
+
+// targetsFile is the JSON inventory pointed to by TARGETS_FILE.
+type targetsFile struct {
+	Targets []targetEntry `json:"targets"`
+}
+
+type targetEntry struct {
+	database.TargetConfig
+	Pool *poolEntry `json:"pool"`
+}
+
+// poolEntry overrides the pool settings from the environment for a single
+// target. Durations use time.ParseDuration syntax, e.g. "30m".
+type poolEntry struct {
+	MaxOpenConns    *int   `json:"max_open_conns"`
+	MaxIdleConns    *int   `json:"max_idle_conns"`
+	ConnMaxLifetime string `json:"conn_max_lifetime"`
+	ConnMaxIdleTime string `json:"conn_max_idle_time"`
+}
+
+func LoadConfig() (*Config, error) {
+	if err := env.Load(".env"); err != nil {
+		return nil, fmt.Errorf("error loading .env file: %w", err)
+	}
+
+	config := &Config{
+		APIKey: os.Getenv("API_KEY"),
+	}
+
+	if config.APIKey == "" {
+		return nil, fmt.Errorf("API_KEY environment variable not set")
+	}
+
+	pool, err := loadPoolConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.HealthCheckPeriod, err = envDuration("DB_HEALTH_CHECK_INTERVAL", 30*time.Second); err != nil {
+		return nil, err
+	}
+
+	if config.Placement, err = manager.ParsePlacementPolicy(os.Getenv("PLACEMENT_POLICY")); err != nil {
+		return nil, err
+	}
+
+	config.CatalogPath = os.Getenv("CATALOG_PATH")
+	if config.CatalogPath == "" {
+		config.CatalogPath = "catalog.db"
+	}
+
+	if config.EncryptionKey, err = loadEncryptionKey(); err != nil {
+		return nil, err
+	}
+	if config.IdempotencyWindow, err = envDuration("IDEMPOTENCY_WINDOW", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if config.JobConcurrency, err = envInt("JOB_CONCURRENCY", 4); err != nil {
+		return nil, err
+	}
+	if config.JobRetention, err = envDuration("JOB_RETENTION", 7*24*time.Hour); err != nil {
+		return nil, err
+	}
+
+	config.LockBackend = os.Getenv("LOCK_BACKEND")
+	switch config.LockBackend {
+	case "", "local":
+	case "postgres", "mysql":
+		config.LockTarget = os.Getenv("LOCK_TARGET")
+		if config.LockTarget == "" {
+			config.LockTarget = config.LockBackend
+		}
+	default:
+		return nil, fmt.Errorf("unknown lock backend %q", config.LockBackend)
+	}
+	if config.LockWait, err = envDuration("LOCK_WAIT", 0); err != nil {
+		return nil, err
+	}
+	if config.RecycleRetention, err = envDuration("RECYCLE_RETENTION", 7*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if config.RotationGrace, err = envDuration("ROTATION_GRACE", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	config.ConfirmEngines = envList("CONFIRM_DESTRUCTIVE")
+	if config.ConfirmationTTL, err = envDuration("CONFIRMATION_TTL", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	config.Names = manager.NamePolicy{
+		Allow:    envList("DATABASE_NAME_ALLOW"),
+		Deny:     envList("DATABASE_NAME_DENY"),
+		Template: os.Getenv("DATABASE_NAME_TEMPLATE"),
+	}
+	for _, pair := range envList("DATABASE_NAME_VARS") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid DATABASE_NAME_VARS entry %q, expected key=value", pair)
+		}
+		if config.Names.Vars == nil {
+			config.Names.Vars = make(map[string]string)
+		}
+		config.Names.Vars[key] = value
+	}
+
+	if path := os.Getenv("TARGETS_FILE"); path != "" {
+		config.Targets, err = loadTargetsFile(path, pool)
+	} else {
+		config.Targets = legacyTargets(pool)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// loadEncryptionKey decodes ENCRYPTION_KEY, the base64-encoded 32-byte key
+// for the secrets kept in the catalog file. Without it a random key is
+// used, so stored responses and job results cannot be read after a
+// restart.
+func loadEncryptionKey() ([]byte, error) {
+	value := os.Getenv("ENCRYPTION_KEY")
+	if value == "" {
+		log.Warn().Msg("ENCRYPTION_KEY is not set, stored responses and job results will not survive a restart")
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		return key, err
+	}
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("ENCRYPTION_KEY must be base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("ENCRYPTION_KEY must decode to 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+func loadPoolConfig() (database.PoolConfig, error) {
+	pool := database.DefaultPoolConfig()
+	var err error
+	if pool.MaxOpenConns, err = envInt("DB_MAX_OPEN_CONNS", pool.MaxOpenConns); err != nil {
+		return pool, err
+	}
+	if pool.MaxIdleConns, err = envInt("DB_MAX_IDLE_CONNS", pool.MaxIdleConns); err != nil {
+		return pool, err
+	}
+	if pool.ConnMaxLifetime, err = envDuration("DB_CONN_MAX_LIFETIME", pool.ConnMaxLifetime); err != nil {
+		return pool, err
+	}
+	if pool.ConnMaxIdleTime, err = envDuration("DB_CONN_MAX_IDLE_TIME", pool.ConnMaxIdleTime); err != nil {
+		return pool, err
+	}
+	return pool, nil
+}
+
+// legacyTargets builds one "default" target per engine from the original
+// single-server environment variables. An engine without a host (or
+// MONGO_URI) is left out.
+func legacyTargets(pool database.PoolConfig) []database.TargetConfig {
+	var targets []database.TargetConfig
+	if host := os.Getenv("MYSQL_DB_HOST"); host != "" {
+		targets = append(targets, database.TargetConfig{
+			Engine:   "mysql",
+			Name:     database.DefaultTargetName,
+			Host:     host,
+			Port:     os.Getenv("MYSQL_DB_PORT"),
+			User:     os.Getenv("MYSQL_DB_USER"),
+			Password: os.Getenv("MYSQL_DB_PASSWORD"),
+			Pool:     pool,
+		})
+	}
+	if uri := os.Getenv("MONGO_URI"); uri != "" {
+		targets = append(targets, database.TargetConfig{
+			Engine: "mongo",
+			Name:   database.DefaultTargetName,
+			URI:    uri,
+			Pool:   pool,
+		})
+	}
+	if host := os.Getenv("POSTGRES_DB_HOST"); host != "" {
+		targets = append(targets, database.TargetConfig{
+			Engine:   "postgres",
+			Name:     database.DefaultTargetName,
+			Host:     host,
+			Port:     os.Getenv("POSTGRES_DB_PORT"),
+			User:     os.Getenv("POSTGRES_DB_USER"),
+			Password: os.Getenv("POSTGRES_DB_PASSWORD"),
+			TLS:      database.TLSConfig{Mode: os.Getenv("SSL_MODE")},
+			Pool:     pool,
+		})
+	}
+	return targets
+}
+
+func loadTargetsFile(path string, pool database.PoolConfig) ([]database.TargetConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading targets file: %w", err)
+	}
+	var file targetsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing targets file: %w", err)
+	}
+
+	targets := make([]database.TargetConfig, 0, len(file.Targets))
+	for _, entry := range file.Targets {
+		target := entry.TargetConfig
+		target.Pool = pool
+		if entry.Pool != nil {
+			if err := entry.Pool.apply(&target.Pool); err != nil {
+				return nil, fmt.Errorf("target %s: %w", target.Ref(), err)
+			}
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+func (p *poolEntry) apply(pool *database.PoolConfig) error {
+	if p.MaxOpenConns != nil {
+		pool.MaxOpenConns = *p.MaxOpenConns
+	}
+	if p.MaxIdleConns != nil {
+		pool.MaxIdleConns = *p.MaxIdleConns
+	}
+	if p.ConnMaxLifetime != "" {
+		d, err := time.ParseDuration(p.ConnMaxLifetime)
+		if err != nil {
+			return fmt.Errorf("invalid conn_max_lifetime: %w", err)
+		}
+		pool.ConnMaxLifetime = d
+	}
+	if p.ConnMaxIdleTime != "" {
+		d, err := time.ParseDuration(p.ConnMaxIdleTime)
+		if err != nil {
+			return fmt.Errorf("invalid conn_max_idle_time: %w", err)
+		}
+		pool.ConnMaxIdleTime = d
+	}
+	return nil
+}
+
+func envInt(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return n, nil
+}
+
+func envDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
+
+// envList splits a comma-separated variable, dropping empty entries.
+func envList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,83 @@
+package database
+
+import "context"
+
+// Engine is implemented by every database server type the manager can
+// provision on. Handlers and routes are built from this interface so that a
+// new engine only has to implement the operations themselves. Each Engine
+// value talks to a single server, the target.
+type Engine interface {
+	Name() string
+	Target() string
+	Create(ctx context.Context, opts CreateOptions) (*Credentials, error)
+	Rename(ctx context.Context, opts RenameOptions) (*RenameResult, error)
+	Delete(ctx context.Context, opts DeleteOptions) error
+	Stats(ctx context.Context, dbName string) (*DatabaseStats, error)
+	// Describe reports what the server knows about one database.
+	Describe(ctx context.Context, dbName string) (*DatabaseInfo, error)
+	ResetCredentials(ctx context.Context, opts ResetCredentialsOptions) (*Credentials, error)
+	List(ctx context.Context) ([]string, error)
+	Usage(ctx context.Context) (*ServerUsage, error)
+	Ping(ctx context.Context) error
+	// IsSystemDatabase reports whether name belongs to the server itself,
+	// such as mysql or template1, and must never be managed.
+	IsSystemDatabase(name string) bool
+	// Connect opens the engine's connection pool and pings the server.
+	Connect(ctx context.Context) error
+	Close() error
+}
+
+// QueryActivityReporter is implemented by engines that can report how many
+// queries each user has run, such as Postgres with pg_stat_statements.
+type QueryActivityReporter interface {
+	TotalQueries(ctx context.Context) ([]QueryActivity, error)
+}
+
+// UserLocker is implemented by engines that can disable a user's logins
+// without dropping it, which keeps recycled databases out of reach until
+// they are restored.
+type UserLocker interface {
+	LockUsers(ctx context.Context, usernames []string) error
+	UnlockUsers(ctx context.Context, usernames []string) error
+}
+
+// Capabilities describes how a rename behaves on an engine's server.
+type Capabilities struct {
+	// RenameCopiesData means a rename copies the data instead of moving
+	// it, which takes as long as the database is big.
+	RenameCopiesData bool `json:"rename_copies_data"`
+	// RenameResetsPasswords means a rename recreates the users of the
+	// database with new passwords, so their old credentials stop working.
+	RenameResetsPasswords bool `json:"rename_resets_passwords"`
+}
+
+// CapabilityReporter is implemented by engines whose renames are not plain,
+// cheap moves on every server.
+type CapabilityReporter interface {
+	Capabilities(ctx context.Context) (Capabilities, error)
+}
+
+// UserManager is implemented by engines that can manage the users of a
+// database one by one, next to the user generated with it.
+type UserManager interface {
+	Users(ctx context.Context, dbName string) ([]DatabaseUser, error)
+	AddUser(ctx context.Context, opts UserOptions) (*Credentials, error)
+	// RemoveUser drops the user, which must be a user of opts.DatabaseName
+	// and of no other database.
+	RemoveUser(ctx context.Context, opts UserOptions) error
+	// ResetPassword gives the user a new random password, leaving the
+	// database's other users alone.
+	ResetPassword(ctx context.Context, opts UserOptions) (*Credentials, error)
+}
+
+// CredentialRotator is implemented by engines that can give a user a new
+// credential while the old one keeps working, so that applications can
+// switch over without downtime.
+type CredentialRotator interface {
+	// StartRotation adds a credential for opts.Username.
+	StartRotation(ctx context.Context, opts UserOptions) (*Rotation, error)
+	// FinishRotation retires the old credential of a rotation; with
+	// ReplacesUser, that drops the retired user. A retired login that no
+	// longer exists counts as retired.
+	FinishRotation(ctx context.Context, rotation Rotation) error
+}
@@ -0,0 +1,181 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// Code classifies an error for API clients. The values are stable and part
+// of the API, unlike error messages.
+type Code string
+
+const (
+	CodeNotFound            Code = "not_found"
+	CodeConflict            Code = "conflict"
+	CodeInvalidArgument     Code = "invalid_argument"
+	CodeUpstreamUnavailable Code = "upstream_unavailable"
+	CodePermissionDenied    Code = "permission_denied"
+	// CodeConfirmationRequired is returned by the API for destructive
+	// requests that must be repeated with a confirmation token.
+	CodeConfirmationRequired Code = "confirmation_required"
+	CodeInternal             Code = "internal"
+)
+
+// OpError records the step of an engine operation that failed, using the
+// same action names the API has always reported to clients, and the code
+// the failure is classified as.
+type OpError struct {
+	Action string
+	// Code is derived from Err when left empty.
+	Code Code
+	Err  error
+}
+
+func (e *OpError) Error() string { return e.Err.Error() }
+
+func (e *OpError) Unwrap() error { return e.Err }
+
+// opError wraps a failed step, classifying driver errors.
+func opError(action string, err error) error {
+	return &OpError{Action: action, Code: classify(err), Err: err}
+}
+
+func codedError(action string, code Code, err error) error {
+	return &OpError{Action: action, Code: code, Err: err}
+}
+
+// ActionOf returns the action attached to err, or fallback when err was not
+// produced by an engine step.
+func ActionOf(err error, fallback string) string {
+	var opErr *OpError
+	if errors.As(err, &opErr) {
+		return opErr.Action
+	}
+	return fallback
+}
+
+// CodeOf returns the code of err. Errors that cannot be classified are
+// CodeInternal.
+func CodeOf(err error) Code {
+	var opErr *OpError
+	if errors.As(err, &opErr) && opErr.Code != "" {
+		return opErr.Code
+	}
+	return classify(err)
+}
+
+// IsServerError reports whether err was returned by a database server, as
+// opposed to failing in the manager itself.
+func IsServerError(err error) bool {
+	var pqErr *pq.Error
+	var mysqlErr *mysql.MySQLError
+	var mongoErr mongo.ServerError
+	return errors.As(err, &pqErr) || errors.As(err, &mysqlErr) || errors.As(err, &mongoErr)
+}
+
+func classify(err error) Code {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return classifyPostgres(pqErr)
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return classifyMySQL(mysqlErr)
+	}
+	var mongoErr mongo.ServerError
+	if errors.As(err, &mongoErr) && !mongo.IsNetworkError(err) {
+		return classifyMongo(mongoErr)
+	}
+	if isUnavailable(err) {
+		return CodeUpstreamUnavailable
+	}
+	return CodeInternal
+}
+
+// isUnavailable recognizes failures to reach a server at all.
+func isUnavailable(err error) bool {
+	var netErr net.Error
+	var selectionErr topology.ServerSelectionError
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.As(err, &netErr) ||
+		errors.As(err, &selectionErr) ||
+		mongo.IsNetworkError(err) ||
+		mongo.IsTimeout(err)
+}
+
+// classifyPostgres maps SQLSTATE codes, see
+// https://www.postgresql.org/docs/current/errcodes-appendix.html.
+func classifyPostgres(err *pq.Error) Code {
+	switch err.Code {
+	case "42P04", "42710", "42P07": // duplicate_database, duplicate_object, duplicate_table
+		return CodeConflict
+	case "55006": // object_in_use
+		return CodeConflict
+	case "3D000", "42704", "42P01": // invalid_catalog_name, undefined_object, undefined_table
+		return CodeNotFound
+	case "42501": // insufficient_privilege
+		return CodePermissionDenied
+	case "42602", "42622", "42601": // invalid_name, name_too_long, syntax_error
+		return CodeInvalidArgument
+	case "53300", "57P01", "57P02", "57P03": // too_many_connections, admin_shutdown, crash_shutdown, cannot_connect_now
+		return CodeUpstreamUnavailable
+	}
+	switch err.Code.Class() {
+	case "08": // connection_exception
+		return CodeUpstreamUnavailable
+	case "28": // invalid_authorization_specification
+		return CodePermissionDenied
+	case "22": // data_exception
+		return CodeInvalidArgument
+	}
+	return CodeInternal
+}
+
+// classifyMySQL maps server error numbers, see
+// https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html.
+func classifyMySQL(err *mysql.MySQLError) Code {
+	switch err.Number {
+	case 1007, 1050, 1396: // ER_DB_CREATE_EXISTS, ER_TABLE_EXISTS_ERROR, ER_CANNOT_USER
+		return CodeConflict
+	case 1008, 1049, 1146: // ER_DB_DROP_EXISTS, ER_BAD_DB_ERROR, ER_NO_SUCH_TABLE
+		return CodeNotFound
+	case 1044, 1045, 1142, 1227: // ER_DBACCESS_DENIED_ERROR, ER_ACCESS_DENIED_ERROR, ER_TABLEACCESS_DENIED_ERROR, ER_SPECIFIC_ACCESS_DENIED_ERROR
+		return CodePermissionDenied
+	case 1059, 1102, 1103: // ER_TOO_LONG_IDENT, ER_WRONG_DB_NAME, ER_WRONG_TABLE_NAME
+		return CodeInvalidArgument
+	case 1115, 1253, 1273: // ER_UNKNOWN_CHARACTER_SET, ER_COLLATION_CHARSET_MISMATCH, ER_UNKNOWN_COLLATION
+		return CodeInvalidArgument
+	case 1040, 1053, 1205: // ER_CON_COUNT_ERROR, ER_SERVER_SHUTDOWN, ER_LOCK_WAIT_TIMEOUT
+		return CodeUpstreamUnavailable
+	}
+	return CodeInternal
+}
+
+// classifyMongo maps server error codes, see
+// https://www.mongodb.com/docs/manual/reference/error-codes/.
+func classifyMongo(err mongo.ServerError) Code {
+	switch {
+	case mongo.IsDuplicateKeyError(err), err.HasErrorCode(48), err.HasErrorCode(51003): // NamespaceExists, user already exists
+		return CodeConflict
+	case err.HasErrorCode(11), err.HasErrorCode(26): // UserNotFound, NamespaceNotFound
+		return CodeNotFound
+	case err.HasErrorCode(13), err.HasErrorCode(18): // Unauthorized, AuthenticationFailed
+		return CodePermissionDenied
+	case err.HasErrorCode(2), err.HasErrorCode(73): // BadValue, InvalidNamespace
+		return CodeInvalidArgument
+	case err.HasErrorCode(67), err.HasErrorCode(72), err.HasErrorCode(85), err.HasErrorCode(86): // CannotCreateIndex, InvalidOptions, IndexOptionsConflict, IndexKeySpecsConflict
+		return CodeInvalidArgument
+	case err.HasErrorCode(91), err.HasErrorCode(189): // ShutdownInProgress, PrimarySteppedDown
+		return CodeUpstreamUnavailable
+	}
+	return CodeInternal
+}
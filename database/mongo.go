@@ -2,324 +2,413 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"time"
+	"regexp"
 
 	"github.com/bonheur15/go-db-manager/utils"
-	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func init() {
-	validate = validator.New()
+// mongoRoles are the built-in roles each access level maps to.
+var mongoRoles = map[Access]string{
+	AccessReadOnly:  "read",
+	AccessReadWrite: "readWrite",
+	AccessAdmin:     "dbOwner",
 }
 
-func ConnectToMongoDB(mongoURI string) (*mongo.Client, context.Context, error) {
-	clientOptions := options.Client().ApplyURI(mongoURI)
-	client, err := mongo.Connect(context.Background(), clientOptions)
-	return client, context.Background(), err
+var mongoSystemDatabases = map[string]bool{
+	"admin":  true,
+	"config": true,
+	"local":  true,
 }
 
-func MongoCreateDatabase(c *gin.Context, mongoURI string) {
-	startTime := time.Now().UnixMilli()
-	var requestBody struct {
-		DatabaseName string `json:"database_name" validate:"required,alphanum"`
+type MongoEngine struct {
+	target   string
+	defaults CreationOptions
+	pool     *mongoPool
+}
+
+func NewMongoEngine(target TargetConfig) (*MongoEngine, error) {
+	tlsConfig, err := target.TLS.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &MongoEngine{
+		target:   target.Name,
+		defaults: target.CreateDefaults,
+		pool:     newMongoPool(target.URI, tlsConfig, target.Pool),
+	}, nil
+}
+
+func (e *MongoEngine) Name() string { return "mongo" }
+
+func (e *MongoEngine) Target() string { return e.target }
+
+func (e *MongoEngine) IsSystemDatabase(name string) bool {
+	return mongoSystemDatabases[name]
+}
+
+func (e *MongoEngine) Connect(ctx context.Context) error {
+	_, err := e.pool.get(ctx)
+	return err
+}
+
+func (e *MongoEngine) Close() error {
+	return e.pool.close()
+}
+
+// mongoDatabaseNames lists the server's databases. MongoDB only lists
+// databases holding data, so databases that merely have users, such as
+// ones created without initial collections, are added from usersInfo.
+func mongoDatabaseNames(ctx context.Context, client *mongo.Client) ([]string, error) {
+	names, err := client.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		return nil, opError("mongo-list-databases", err)
 	}
-	if err := c.BindJSON(&requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-bind-json")
-		return
+	var usersInfo struct {
+		Users []struct {
+			DB string `bson:"db"`
+		} `bson:"users"`
+	}
+	cmd := bson.D{{Key: "usersInfo", Value: bson.D{{Key: "forAllDBs", Value: true}}}}
+	if err := client.Database("admin").RunCommand(ctx, cmd).Decode(&usersInfo); err != nil {
+		return nil, opError("mongo-users-info", err)
 	}
 
-	if err := validate.Struct(requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-validation")
-		return
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		seen[name] = true
 	}
+	for _, user := range usersInfo.Users {
+		if !seen[user.DB] {
+			seen[user.DB] = true
+			names = append(names, user.DB)
+		}
+	}
+	return names, nil
+}
 
-	client, ctx, err := ConnectToMongoDB(mongoURI)
+// mongoDatabaseExists reports whether dbName is one of the server's databases.
+func mongoDatabaseExists(ctx context.Context, client *mongo.Client, dbName string) (bool, error) {
+	existingDatabases, err := mongoDatabaseNames(ctx, client)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-connection-open")
-		return
+		return false, err
 	}
-	defer client.Disconnect(ctx)
+	for _, name := range existingDatabases {
+		if name == dbName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
 
-	existingDatabases, err := client.ListDatabaseNames(ctx, bson.M{})
+func (e *MongoEngine) Create(ctx context.Context, opts CreateOptions) (*Credentials, error) {
+	dbName := opts.DatabaseName
+	client, err := e.pool.get(ctx)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-list-databases")
-		return
+		return nil, err
 	}
 
-	for _, dbName := range existingDatabases {
-		if dbName == requestBody.DatabaseName {
-			utils.ErrorResponse(c, fmt.Errorf("database %s already exists", requestBody.DatabaseName), startTime, "mongo-database-exists")
-			return
-		}
+	collections := opts.Options.withDefaults(e.defaults).Collections
+	if err := mongoCheckCollections(collections); err != nil {
+		return nil, err
 	}
 
-	collection := client.Database(requestBody.DatabaseName).Collection("test")
-	if _, err := collection.InsertOne(ctx, bson.M{"test": "data"}); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-create-database")
-		return
+	exists, err := mongoDatabaseExists(ctx, client, dbName)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, codedError("mongo-database-exists", CodeConflict, fmt.Errorf("database %s already exists", dbName))
+	}
+
+	access := opts.Access.Or(DefaultAccess("mongo"))
+	db := client.Database(dbName)
+	s := newSaga("mongo")
+	// MongoDB creates a database with its first collection or user, so
+	// there is only a step of its own when collections were asked for.
+	// The database did not exist before, so dropping it also undoes the
+	// collections created before one failed.
+	if len(collections) > 0 {
+		err = s.runPartial(ctx, "create-collections", func(ctx context.Context) error {
+			return mongoCreateCollections(ctx, db, collections)
+		}, func(ctx context.Context) error {
+			return db.Drop(ctx)
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	username, err := utils.RandomString(12)
+	username, password, err := mongoCreateUser(ctx, db, "", access, s)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-create-user-random-string")
-		return
+		return nil, err
+	}
+
+	return &Credentials{Username: username, Password: password, DatabaseName: dbName, Access: access, Steps: s.Steps()}, nil
+}
+
+// mongoCreateUser creates a user of db as a step of s. The user gets a
+// random name when username is empty.
+func mongoCreateUser(ctx context.Context, db *mongo.Database, username string, access Access, s *saga) (string, string, error) {
+	var err error
+	if username == "" {
+		username, err = utils.RandomString(12)
+		if err != nil {
+			return "", "", opError("mongo-create-user-random-string", err)
+		}
 	}
 	password, err := utils.RandomString(16)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-create-user-random-string")
-		return
+		return "", "", opError("mongo-create-user-random-string", err)
 	}
 
-	db := client.Database(requestBody.DatabaseName)
-	createUserCmd := bson.D{
-		{"createUser", username},
-		{"pwd", password},
-		{"roles", bson.A{bson.D{{"role", "readWrite"}, {"db", requestBody.DatabaseName}}}},
-	}
-	if err := db.RunCommand(ctx, createUserCmd).Err(); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-create-user")
-		return
+	err = s.run(ctx, "create-user", func(ctx context.Context) error {
+		createUserCmd := bson.D{
+			{Key: "createUser", Value: username},
+			{Key: "pwd", Value: password},
+			{Key: "roles", Value: mongoUserRoles(db.Name(), access)},
+		}
+		return db.RunCommand(ctx, createUserCmd).Err()
+	}, func(ctx context.Context) error {
+		return db.RunCommand(ctx, bson.D{{Key: "dropUser", Value: username}}).Err()
+	})
+	if err != nil {
+		return "", "", err
 	}
-
-	utils.SuccessResponse(c, map[string]interface{}{
-		"username":      username,
-		"password":      password,
-		"database_name": requestBody.DatabaseName,
-	}, startTime, "mongo-create-database", "Database Created")
+	return username, password, nil
 }
 
-func MongoCopyDatabase(oldName, newName string, client *mongo.Client) error {
-	oldDB := client.Database(oldName)
-	newDB := client.Database(newName)
+func mongoUserRoles(dbName string, access Access) bson.A {
+	return bson.A{bson.D{{Key: "role", Value: mongoRoles[access]}, {Key: "db", Value: dbName}}}
+}
 
-	collections, err := oldDB.ListCollectionNames(context.Background(), bson.D{})
+func (e *MongoEngine) Delete(ctx context.Context, opts DeleteOptions) error {
+	dbName := opts.DatabaseName
+	client, err := e.pool.get(ctx)
 	if err != nil {
 		return err
 	}
 
-	for _, collection := range collections {
-		oldColl := oldDB.Collection(collection)
-		newColl := newDB.Collection(collection)
-
-		cursor, err := oldColl.Find(context.Background(), bson.D{})
-		if err != nil {
-			return err
-		}
-
-		var documents []interface{}
-		if err = cursor.All(context.Background(), &documents); err != nil {
-			return err
-		}
-
-		if len(documents) > 0 {
-			_, err = newColl.InsertMany(context.Background(), documents)
-			if err != nil {
-				return err
-			}
-		}
+	exists, err := mongoDatabaseExists(ctx, client, dbName)
+	if err != nil {
+		return err
 	}
-	return nil
-}
-
-func MongoRenameDatabase(c *gin.Context, mongoURI string) {
-	startTime := time.Now().UnixMilli()
-	var requestBody struct {
-		OldDatabaseName string `json:"old_database_name" validate:"required,alphanum"`
-		NewDatabaseName string `json:"new_database_name" validate:"required,alphanum"`
+	if !exists {
+		return codedError("mongo-database-not-exist", CodeNotFound, fmt.Errorf("database %s does not exist", dbName))
 	}
-	if err := c.BindJSON(&requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-bind-json")
-		return
+
+	// Mongo users live in the database they were created in, so they have
+	// to be dropped before the database itself. All of them are dropped,
+	// not only the ones the catalog knows: a database left with users
+	// would still be listed and block creating another one by its name.
+	if err := client.Database(dbName).RunCommand(ctx, bson.D{{Key: "dropAllUsersFromDatabase", Value: 1}}).Err(); err != nil {
+		return opError("mongo-drop-users", err)
 	}
 
-	if err := validate.Struct(requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-validation")
-		return
+	if err := client.Database(dbName).Drop(ctx); err != nil {
+		return opError("mongo-drop-database", err)
 	}
+	return nil
+}
 
-	client, ctx, err := ConnectToMongoDB(mongoURI)
+func (e *MongoEngine) Stats(ctx context.Context, dbName string) (*DatabaseStats, error) {
+	client, err := e.pool.get(ctx)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-connection-open")
-		return
+		return nil, err
 	}
-	defer client.Disconnect(ctx)
-	existingDatabases, err := client.ListDatabaseNames(ctx, bson.M{})
+
+	db := client.Database(dbName)
+	collections, err := db.ListCollectionNames(ctx, bson.D{})
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-list-databases")
-		return
-	}
-	dbexist := false
-	for _, dbName := range existingDatabases {
-		if dbName == requestBody.OldDatabaseName {
-			dbexist = true
-			break
-		}
-	}
-	if !dbexist {
-		utils.ErrorResponse(c, fmt.Errorf("database %s does not exist", requestBody.OldDatabaseName), startTime, "mongo-database-not-exist")
-		return
+		return nil, opError("mongo-list-collections", err)
 	}
 
-	for _, dbName := range existingDatabases {
-		if dbName == requestBody.NewDatabaseName {
-			utils.ErrorResponse(c, fmt.Errorf("database %s already exists", requestBody.NewDatabaseName), startTime, "mongo-database-exists")
-			return
+	var stats []map[string]interface{}
+	for _, collectionName := range collections {
+		collectionStats := map[string]interface{}{}
+		if err := db.RunCommand(ctx, bson.D{{Key: "collStats", Value: collectionName}}).Decode(&collectionStats); err != nil {
+			return nil, opError("mongo-collection-stats", err)
 		}
+		stats = append(stats, collectionStats)
 	}
 
-	err = MongoCopyDatabase(requestBody.OldDatabaseName, requestBody.NewDatabaseName, client)
-	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-copy-database")
-		return
-	}
-	client.Database(requestBody.OldDatabaseName).Drop(context.Background())
-	utils.SuccessResponse(c, map[string]interface{}{
-		"old_database_name": requestBody.OldDatabaseName,
-		"new_database_name": requestBody.NewDatabaseName,
-	}, startTime, "mongo-rename-database", "Database Renamed")
+	return &DatabaseStats{DatabaseName: dbName, Collections: stats}, nil
 }
 
-func MongoDeleteDatabase(c *gin.Context, mongoURI string) {
-	startTime := time.Now().UnixMilli()
-	var requestBody struct {
-		DatabaseName string `json:"database_name" validate:"required,alphanum"`
+func (e *MongoEngine) ResetCredentials(ctx context.Context, opts ResetCredentialsOptions) (*Credentials, error) {
+	dbName, username := opts.DatabaseName, opts.Username
+	if username == "" && len(opts.Users) == 1 {
+		username = opts.Users[0]
 	}
-	if err := c.BindJSON(&requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-bind-json")
-		return
+	if username == "" {
+		return nil, codedError("mongo-validation", CodeInvalidArgument, errors.New("username is required to reset mongo credentials"))
 	}
 
-	if err := validate.Struct(requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-validation")
-		return
-	}
-
-	client, ctx, err := ConnectToMongoDB(mongoURI)
+	client, err := e.pool.get(ctx)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-connection-open")
-		return
+		return nil, err
 	}
-	defer client.Disconnect(ctx)
 
-	existingDatabases, err := client.ListDatabaseNames(ctx, bson.M{})
-	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-list-databases")
-		return
-	}
-	dbexist := false
-	for _, dbName := range existingDatabases {
-		if dbName == requestBody.DatabaseName {
-			dbexist = true
-			break
+	db := client.Database(dbName)
+
+	// A user has a single password, so keeping the old one working takes
+	// a new user.
+	if opts.KeepUsers {
+		replacement, password, err := mongoReplaceUser(ctx, db, username, opts.Access)
+		if err != nil {
+			return nil, err
 		}
+		return &Credentials{Username: replacement, Password: password, DatabaseName: dbName, Access: opts.Access, Replaced: []string{username}}, nil
 	}
-	if !dbexist {
-		utils.ErrorResponse(c, fmt.Errorf("database %s does not exist", requestBody.DatabaseName), startTime, "mongo-database-not-exist")
-		return
+
+	newPassword, err := utils.RandomString(16)
+	if err != nil {
+		return nil, opError("mongo-create-user-random-string", err)
 	}
 
-	if err := client.Database(requestBody.DatabaseName).Drop(ctx); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-drop-database")
-		return
+	updateCmd := bson.D{
+		{Key: "updateUser", Value: username},
+		{Key: "pwd", Value: newPassword},
+	}
+	if opts.Access != "" {
+		updateCmd = append(updateCmd, bson.E{Key: "roles", Value: mongoUserRoles(dbName, opts.Access)})
+	}
+	if err := db.RunCommand(ctx, updateCmd).Err(); err != nil {
+		return nil, opError("mongo-reset-credentials", err)
 	}
 
-	utils.SuccessResponse(c, map[string]interface{}{
-		"database_name": requestBody.DatabaseName,
-	}, startTime, "mongo-delete-database", "Database Deleted")
+	return &Credentials{Username: username, Password: newPassword, Access: opts.Access}, nil
 }
 
-func MongoViewDatabaseStats(c *gin.Context, mongoURI string) {
-	startTime := time.Now().UnixMilli()
-	var requestBody struct {
-		DatabaseName string `json:"database_name" validate:"required,alphanum"`
-	}
-	if err := c.BindJSON(&requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-bind-json")
-		return
+func (e *MongoEngine) List(ctx context.Context) ([]string, error) {
+	client, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := validate.Struct(requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-validation")
-		return
+	existingDatabases, err := mongoDatabaseNames(ctx, client)
+	if err != nil {
+		return nil, err
 	}
 
-	client, ctx, err := ConnectToMongoDB(mongoURI)
-	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-connection-open")
-		return
+	var names []string
+	for _, name := range existingDatabases {
+		if mongoSystemDatabases[name] {
+			continue
+		}
+		names = append(names, name)
 	}
-	defer client.Disconnect(ctx)
+	return names, nil
+}
 
-	db := client.Database(requestBody.DatabaseName)
-	collections, err := db.ListCollectionNames(ctx, bson.D{})
+func (e *MongoEngine) Describe(ctx context.Context, dbName string) (*DatabaseInfo, error) {
+	client, err := e.pool.get(ctx)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-list-collections")
-		return
+		return nil, err
 	}
 
-	var stats []bson.M
-	for _, collectionName := range collections {
-		collectionStats := bson.M{}
-		if err := db.RunCommand(ctx, bson.D{{"collStats", collectionName}}).Decode(&collectionStats); err != nil {
-			utils.ErrorResponse(c, err, startTime, "mongo-collection-stats")
-			return
+	result, err := client.ListDatabases(ctx, bson.D{{Key: "name", Value: dbName}})
+	if err != nil {
+		return nil, opError("mongo-list-databases", err)
+	}
+	info := &DatabaseInfo{DatabaseName: dbName}
+	if len(result.Databases) > 0 {
+		info.SizeBytes = result.Databases[0].SizeOnDisk
+	} else {
+		// A database without collections is not listed, but exists while
+		// it has users.
+		exists, err := mongoDatabaseExists(ctx, client, dbName)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, codedError("mongo-database-not-exist", CodeNotFound, fmt.Errorf("database %s does not exist", dbName))
 		}
-		stats = append(stats, collectionStats)
 	}
 
-	utils.SuccessResponse(c, map[string]interface{}{
-		"database_name": requestBody.DatabaseName,
-		"stats":         stats,
-	}, startTime, "mongo-view-database-stats", "Database Statistics Retrieved")
-}
-
-func MongoResetCredentials(c *gin.Context, mongoURI string) {
-	startTime := time.Now().UnixMilli()
-	var requestBody struct {
-		DatabaseName string `json:"database_name" validate:"required,alphanum"`
-		Username     string `json:"username" validate:"required,alphanum"`
+	// MongoDB does not tie connections to a database; count the
+	// connections running operations in it instead.
+	pipeline := mongo.Pipeline{
+		{{Key: "$currentOp", Value: bson.D{{Key: "allUsers", Value: true}}}},
+		{{Key: "$match", Value: bson.D{{Key: "ns", Value: bson.D{{Key: "$regex", Value: "^" + regexp.QuoteMeta(dbName) + `\.`}}}}}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$connectionId"}}}},
 	}
-	if err := c.BindJSON(&requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-bind-json")
-		return
+	cursor, err := client.Database("admin").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, opError("mongo-current-op", err)
+	}
+	var connections []bson.M
+	if err := cursor.All(ctx, &connections); err != nil {
+		return nil, opError("mongo-current-op", err)
 	}
+	info.Connections = len(connections)
 
-	if err := validate.Struct(requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-validation")
-		return
+	var usersInfo struct {
+		Users []struct {
+			User string `bson:"user"`
+		} `bson:"users"`
+	}
+	if err := client.Database(dbName).RunCommand(ctx, bson.D{{Key: "usersInfo", Value: 1}}).Decode(&usersInfo); err != nil {
+		return nil, opError("mongo-users-info", err)
 	}
+	info.Users = []string{}
+	for _, user := range usersInfo.Users {
+		info.Users = append(info.Users, user.User)
+	}
+	return info, nil
+}
 
-	client, ctx, err := ConnectToMongoDB(mongoURI)
+func (e *MongoEngine) Usage(ctx context.Context) (*ServerUsage, error) {
+	client, err := e.pool.get(ctx)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-connection-open")
-		return
+		return nil, err
 	}
-	defer client.Disconnect(ctx)
-
-	db := client.Database(requestBody.DatabaseName)
 
-	newPassword, err := utils.RandomString(16)
+	result, err := client.ListDatabases(ctx, bson.M{})
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-create-user-random-string")
-		return
+		return nil, opError("mongo-list-databases", err)
+	}
+	usage := &ServerUsage{UsedBytes: result.TotalSize}
+	for _, spec := range result.Databases {
+		if !mongoSystemDatabases[spec.Name] {
+			usage.DatabaseCount++
+		}
 	}
 
-	updateCmd := bson.D{
-		{"updateUser", requestBody.Username},
-		{"pwd", newPassword},
+	var status struct {
+		Connections struct {
+			Current   int `bson:"current"`
+			Available int `bson:"available"`
+		} `bson:"connections"`
 	}
-	if err := db.RunCommand(ctx, updateCmd).Err(); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mongo-reset-credentials")
-		return
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "serverStatus", Value: 1}}).Decode(&status); err != nil {
+		return nil, opError("mongo-server-status", err)
 	}
+	usage.Connections = status.Connections.Current
+	usage.MaxConnections = status.Connections.Current + status.Connections.Available
+	return usage, nil
+}
 
-	utils.SuccessResponse(c, map[string]interface{}{
-		"username": requestBody.Username,
-		"password": newPassword,
-	}, startTime, "mongo-reset-credentials", "Credentials Reset")
+func (e *MongoEngine) Ping(ctx context.Context) error {
+	return e.pool.ping(ctx)
+}
+
+// Capabilities reports that renames always recreate the users with new
+// passwords, and copy the data on sharded clusters, where the server is a
+// mongos router that cannot rename collections across databases.
+func (e *MongoEngine) Capabilities(ctx context.Context) (Capabilities, error) {
+	client, err := e.pool.get(ctx)
+	if err != nil {
+		return Capabilities{}, opError("mongo-capabilities", err)
+	}
+	var hello struct {
+		Msg string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello); err != nil {
+		return Capabilities{}, opError("mongo-capabilities", err)
+	}
+	return Capabilities{RenameCopiesData: hello.Msg == "isdbgrid", RenameResetsPasswords: true}, nil
 }
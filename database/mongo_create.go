@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoIndexOrders maps the orders of MongoIndexKey to index key values.
+var mongoIndexOrders = map[string]interface{}{
+	"":         1,
+	"asc":      1,
+	"desc":     -1,
+	"text":     "text",
+	"2dsphere": "2dsphere",
+	"hashed":   "hashed",
+}
+
+// mongoCheckCollections rejects initial collections the server would
+// refuse, before anything is created.
+func mongoCheckCollections(collections []MongoCollection) error {
+	var fields []FieldError
+	seen := make(map[string]bool)
+	for i, collection := range collections {
+		path := fmt.Sprintf("options.collections[%d]", i)
+		switch {
+		case strings.ContainsAny(collection.Name, "$\x00"):
+			fields = append(fields, FieldError{Field: path + ".name", Rule: "collection", Message: "must not contain $ or null characters"})
+		case strings.HasPrefix(collection.Name, "system."):
+			fields = append(fields, FieldError{Field: path + ".name", Rule: "collection", Message: "must not start with system."})
+		case seen[collection.Name]:
+			fields = append(fields, FieldError{Field: path + ".name", Rule: "unique", Message: "is listed more than once"})
+		}
+		seen[collection.Name] = true
+		if collection.Capped != nil && collection.TimeSeries != nil {
+			fields = append(fields, FieldError{Field: path + ".time_series", Rule: "excluded_with", Message: "cannot be combined with capped"})
+		}
+	}
+	if fields != nil {
+		return codedError("mongo-check-collections", CodeInvalidArgument, &ValidationError{Fields: fields})
+	}
+	return nil
+}
+
+// mongoCreateCollections creates collections in db with their options and
+// indexes.
+func mongoCreateCollections(ctx context.Context, db *mongo.Database, collections []MongoCollection) error {
+	for i, collection := range collections {
+		if err := db.RunCommand(ctx, mongoCreateCommand(collection)).Err(); err != nil {
+			return fmt.Errorf("creating collection %s: %w", collection.Name, err)
+		}
+		if len(collection.Indexes) > 0 {
+			if _, err := db.Collection(collection.Name).Indexes().CreateMany(ctx, mongoIndexModels(collection.Indexes)); err != nil {
+				return fmt.Errorf("creating indexes on collection %s: %w", collection.Name, err)
+			}
+		}
+		reportProgress(ctx, i+1, len(collections), "created collection "+collection.Name)
+	}
+	return nil
+}
+
+func mongoCreateCommand(collection MongoCollection) bson.D {
+	cmd := bson.D{{Key: "create", Value: collection.Name}}
+	if capped := collection.Capped; capped != nil {
+		cmd = append(cmd, bson.E{Key: "capped", Value: true}, bson.E{Key: "size", Value: capped.SizeBytes})
+		if capped.MaxDocuments > 0 {
+			cmd = append(cmd, bson.E{Key: "max", Value: capped.MaxDocuments})
+		}
+	}
+	if timeSeries := collection.TimeSeries; timeSeries != nil {
+		spec := bson.D{{Key: "timeField", Value: timeSeries.TimeField}}
+		if timeSeries.MetaField != "" {
+			spec = append(spec, bson.E{Key: "metaField", Value: timeSeries.MetaField})
+		}
+		if timeSeries.Granularity != "" {
+			spec = append(spec, bson.E{Key: "granularity", Value: timeSeries.Granularity})
+		}
+		cmd = append(cmd, bson.E{Key: "timeseries", Value: spec})
+		if timeSeries.ExpireAfterSeconds != nil {
+			cmd = append(cmd, bson.E{Key: "expireAfterSeconds", Value: *timeSeries.ExpireAfterSeconds})
+		}
+	}
+	if collection.JSONSchema != nil {
+		cmd = append(cmd, bson.E{Key: "validator", Value: bson.D{{Key: "$jsonSchema", Value: collection.JSONSchema}}})
+	}
+	if collection.ValidationLevel != "" {
+		cmd = append(cmd, bson.E{Key: "validationLevel", Value: collection.ValidationLevel})
+	}
+	if collection.ValidationAction != "" {
+		cmd = append(cmd, bson.E{Key: "validationAction", Value: collection.ValidationAction})
+	}
+	return cmd
+}
+
+func mongoIndexModels(indexes []MongoIndex) []mongo.IndexModel {
+	models := make([]mongo.IndexModel, len(indexes))
+	for i, index := range indexes {
+		keys := bson.D{}
+		for _, key := range index.Keys {
+			keys = append(keys, bson.E{Key: key.Field, Value: mongoIndexOrders[key.Order]})
+		}
+		opts := options.Index()
+		if index.Name != "" {
+			opts.SetName(index.Name)
+		}
+		if index.Unique {
+			opts.SetUnique(true)
+		}
+		if index.Sparse {
+			opts.SetSparse(true)
+		}
+		if index.ExpireAfterSeconds != nil {
+			opts.SetExpireAfterSeconds(*index.ExpireAfterSeconds)
+		}
+		models[i] = mongo.IndexModel{Keys: keys, Options: opts}
+	}
+	return models
+}
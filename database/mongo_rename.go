@@ -0,0 +1,348 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bonheur15/go-db-manager/utils"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoCopyBatchSize is the number of documents read and inserted at a
+// time when a collection has to be copied.
+const mongoCopyBatchSize = 1000
+
+// Rename moves every collection to the new database, recreates views and
+// the database's users there, and drops the source last. Collections are
+// moved with renameCollection where the server supports it, and copied
+// otherwise. A failure before the source is dropped moves everything back,
+// including the collections and users of a step that stopped part-way.
+func (e *MongoEngine) Rename(ctx context.Context, opts RenameOptions) (*RenameResult, error) {
+	oldName, newName := opts.OldDatabaseName, opts.NewDatabaseName
+	client, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := mongoDatabaseExists(ctx, client, oldName)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, codedError("mongo-database-not-exist", CodeNotFound, fmt.Errorf("database %s does not exist", oldName))
+	}
+
+	exists, err = mongoDatabaseExists(ctx, client, newName)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, codedError("mongo-database-exists", CodeConflict, fmt.Errorf("database %s already exists", newName))
+	}
+
+	s := newSaga("mongo")
+	var moved []string
+	// Both steps stop part-way on a failure, with moved and users telling
+	// what was done so far.
+	err = s.runPartial(ctx, "copy-database", func(ctx context.Context) error {
+		var err error
+		moved, err = mongoMoveDatabase(ctx, client, oldName, newName)
+		return err
+	}, func(ctx context.Context) error {
+		// A collection that cannot be moved back only exists in newName,
+		// which is then kept.
+		for _, collection := range moved {
+			if err := mongoRenameCollection(ctx, client, newName, oldName, collection); err != nil {
+				return fmt.Errorf("moving collection %s back to %s, %s was kept: %w", collection, oldName, newName, err)
+			}
+		}
+		return client.Database(newName).Drop(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var users []Credentials
+	err = s.runPartial(ctx, "migrate-users", func(ctx context.Context) error {
+		var err error
+		users, err = mongoMigrateUsers(ctx, client, oldName, newName)
+		return err
+	}, func(ctx context.Context) error {
+		for _, user := range users {
+			if err := client.Database(newName).RunCommand(ctx, bson.D{{Key: "dropUser", Value: user.Username}}).Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.run(ctx, "drop-old-database", func(ctx context.Context) error {
+		return client.Database(oldName).Drop(ctx)
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	// The source is gone, so there is nothing left to roll back to.
+	if err := client.Database(oldName).RunCommand(ctx, bson.D{{Key: "dropAllUsersFromDatabase", Value: 1}}).Err(); err != nil {
+		log.Error().Err(err).Str("action", "mongo-drop-old-users").Msg(err.Error())
+	}
+
+	return &RenameResult{OldDatabaseName: oldName, NewDatabaseName: newName, Users: users, PasswordsReset: len(users) > 0, Steps: s.Steps()}, nil
+}
+
+// mongoMoveDatabase moves the collections of oldName to newName and
+// recreates its views there. It returns the collections that were moved
+// with renameCollection; the others were copied and are still in oldName.
+func mongoMoveDatabase(ctx context.Context, client *mongo.Client, oldName, newName string) ([]string, error) {
+	oldDB, newDB := client.Database(oldName), client.Database(newName)
+	collections, views, err := mongoCollections(ctx, oldDB)
+	if err != nil {
+		return nil, err
+	}
+
+	var moved []string
+	renameSupported := true
+	for i, spec := range collections {
+		reportProgress(ctx, i, len(collections), "moving collection "+spec.Name)
+
+		// Time-series collections cannot be renamed across databases.
+		if renameSupported && spec.Type == "collection" {
+			count, err := oldDB.Collection(spec.Name).CountDocuments(ctx, bson.D{})
+			if err != nil {
+				return moved, err
+			}
+			err = mongoRenameCollection(ctx, client, oldName, newName, spec.Name)
+			if err == nil {
+				moved = append(moved, spec.Name)
+				if err := mongoVerifyCount(ctx, newDB, spec.Name, count); err != nil {
+					return moved, err
+				}
+				continue
+			}
+			if !mongoRenameUnsupported(err) {
+				return moved, err
+			}
+			// Sharded clusters do not rename across databases; copy
+			// this and the remaining collections instead.
+			renameSupported = false
+		}
+
+		if err := mongoCopyCollection(ctx, oldDB, newDB, spec); err != nil {
+			return moved, err
+		}
+	}
+	reportProgress(ctx, len(collections), len(collections), "creating views")
+
+	return moved, mongoCreateViews(ctx, newDB, views)
+}
+
+// mongoCollections lists the collections and views of db, leaving out
+// system collections.
+func mongoCollections(ctx context.Context, db *mongo.Database) (collections, views []mongo.CollectionSpecification, err error) {
+	specs, err := db.ListCollectionSpecifications(ctx, bson.D{})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, spec := range specs {
+		switch {
+		case strings.HasPrefix(spec.Name, "system."):
+		case spec.Type == "view":
+			views = append(views, *spec)
+		default:
+			collections = append(collections, *spec)
+		}
+	}
+	return collections, views, nil
+}
+
+func mongoRenameCollection(ctx context.Context, client *mongo.Client, fromDB, toDB, collection string) error {
+	return client.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "renameCollection", Value: fromDB + "." + collection},
+		{Key: "to", Value: toDB + "." + collection},
+	}).Err()
+}
+
+// mongoRenameUnsupported recognizes the errors of servers that cannot
+// rename collections across databases.
+func mongoRenameUnsupported(err error) bool {
+	serverErr, ok := err.(mongo.ServerError)
+	return ok && (serverErr.HasErrorCode(20) || serverErr.HasErrorCode(115)) // IllegalOperation, CommandNotSupported
+}
+
+// mongoCreateLike creates a collection or view in db with the options of
+// spec, which include validators, capped sizes, collations and time-series
+// settings.
+func mongoCreateLike(ctx context.Context, db *mongo.Database, spec mongo.CollectionSpecification) error {
+	cmd := bson.D{{Key: "create", Value: spec.Name}}
+	elements, err := spec.Options.Elements()
+	if err != nil {
+		return err
+	}
+	for _, element := range elements {
+		cmd = append(cmd, bson.E{Key: element.Key(), Value: element.Value()})
+	}
+	return db.RunCommand(ctx, cmd).Err()
+}
+
+func mongoCreateViews(ctx context.Context, db *mongo.Database, views []mongo.CollectionSpecification) error {
+	for _, view := range views {
+		if err := mongoCreateLike(ctx, db, view); err != nil {
+			return fmt.Errorf("creating view %s: %w", view.Name, err)
+		}
+	}
+	return nil
+}
+
+// mongoCopyCollection recreates a collection in newDB and streams its
+// documents there in batches, then copies its indexes.
+func mongoCopyCollection(ctx context.Context, oldDB, newDB *mongo.Database, spec mongo.CollectionSpecification) error {
+	source := oldDB.Collection(spec.Name)
+	count, err := source.CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	if err := mongoCreateLike(ctx, newDB, spec); err != nil {
+		return fmt.Errorf("creating collection %s: %w", spec.Name, err)
+	}
+
+	cursor, err := source.Find(ctx, bson.D{}, options.Find().SetBatchSize(mongoCopyBatchSize))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	target := newDB.Collection(spec.Name)
+	batch := make([]interface{}, 0, mongoCopyBatchSize)
+	for cursor.Next(ctx) {
+		batch = append(batch, append(bson.Raw(nil), cursor.Current...))
+		if len(batch) == mongoCopyBatchSize {
+			if _, err := target.InsertMany(ctx, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		if _, err := target.InsertMany(ctx, batch); err != nil {
+			return err
+		}
+	}
+
+	if err := mongoCopyIndexes(ctx, source, newDB, spec.Name); err != nil {
+		return err
+	}
+	return mongoVerifyCount(ctx, newDB, spec.Name, count)
+}
+
+func mongoCopyIndexes(ctx context.Context, source *mongo.Collection, newDB *mongo.Database, name string) error {
+	cursor, err := source.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var indexes bson.A
+	for cursor.Next(ctx) {
+		// The _id index comes with the collection.
+		if name, _ := cursor.Current.Lookup("name").StringValueOK(); name == "_id_" {
+			continue
+		}
+		elements, err := cursor.Current.Elements()
+		if err != nil {
+			return err
+		}
+		var index bson.D
+		for _, element := range elements {
+			// The version and namespace are set by the server.
+			if element.Key() == "v" || element.Key() == "ns" {
+				continue
+			}
+			index = append(index, bson.E{Key: element.Key(), Value: element.Value()})
+		}
+		indexes = append(indexes, index)
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if len(indexes) == 0 {
+		return nil
+	}
+	err = newDB.RunCommand(ctx, bson.D{
+		{Key: "createIndexes", Value: name},
+		{Key: "indexes", Value: indexes},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("creating indexes of %s: %w", name, err)
+	}
+	return nil
+}
+
+func mongoVerifyCount(ctx context.Context, db *mongo.Database, name string, expected int64) error {
+	count, err := db.Collection(name).CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	if count != expected {
+		return fmt.Errorf("collection %s has %d documents after the copy, expected %d", name, count, expected)
+	}
+	return nil
+}
+
+// mongoMigrateUsers recreates the users of oldName in newName, with their
+// roles on oldName pointed at newName. MongoDB does not expose password
+// hashes in a form createUser accepts, so the users get new passwords,
+// which are returned.
+func mongoMigrateUsers(ctx context.Context, client *mongo.Client, oldName, newName string) ([]Credentials, error) {
+	var usersInfo struct {
+		Users []struct {
+			User       string   `bson:"user"`
+			Roles      []bson.M `bson:"roles"`
+			CustomData bson.Raw `bson:"customData,omitempty"`
+		} `bson:"users"`
+	}
+	err := client.Database(oldName).RunCommand(ctx, bson.D{
+		{Key: "usersInfo", Value: 1},
+		{Key: "showCustomData", Value: true},
+	}).Decode(&usersInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrated []Credentials
+	for _, user := range usersInfo.Users {
+		password, err := utils.RandomString(16)
+		if err != nil {
+			return migrated, err
+		}
+		roles := bson.A{}
+		for _, role := range user.Roles {
+			if role["db"] == oldName {
+				role["db"] = newName
+			}
+			roles = append(roles, role)
+		}
+		cmd := bson.D{
+			{Key: "createUser", Value: user.User},
+			{Key: "pwd", Value: password},
+			{Key: "roles", Value: roles},
+		}
+		if user.CustomData != nil {
+			cmd = append(cmd, bson.E{Key: "customData", Value: user.CustomData})
+		}
+		if err := client.Database(newName).RunCommand(ctx, cmd).Err(); err != nil {
+			return migrated, fmt.Errorf("creating user %s: %w", user.User, err)
+		}
+		migrated = append(migrated, Credentials{Username: user.User, Password: password, DatabaseName: newName})
+	}
+	return migrated, nil
+}
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bonheur15/go-db-manager/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type mongoUserInfo struct {
+	User  string `bson:"user"`
+	Roles []struct {
+		Role string `bson:"role"`
+		DB   string `bson:"db"`
+	} `bson:"roles"`
+}
+
+// mongoUsers lists the users defined in db.
+func mongoUsers(ctx context.Context, db *mongo.Database) ([]mongoUserInfo, error) {
+	var usersInfo struct {
+		Users []mongoUserInfo `bson:"users"`
+	}
+	if err := db.RunCommand(ctx, bson.D{{Key: "usersInfo", Value: 1}}).Decode(&usersInfo); err != nil {
+		return nil, opError("mongo-users-info", err)
+	}
+	return usersInfo.Users, nil
+}
+
+// mongoAccessRank orders access levels, for users with several roles.
+var mongoAccessRank = map[Access]int{AccessReadOnly: 1, AccessReadWrite: 2, AccessAdmin: 3}
+
+// Users lists the users defined in dbName. Their access level is the
+// highest one among their roles on dbName.
+func (e *MongoEngine) Users(ctx context.Context, dbName string) ([]DatabaseUser, error) {
+	client, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := mongoCheckDatabase(ctx, client, dbName); err != nil {
+		return nil, err
+	}
+	infos, err := mongoUsers(ctx, client.Database(dbName))
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]DatabaseUser, len(infos))
+	for i, info := range infos {
+		users[i].Username = info.User
+		for _, role := range info.Roles {
+			if role.DB != dbName {
+				continue
+			}
+			for access, name := range mongoRoles {
+				if role.Role == name && mongoAccessRank[access] > mongoAccessRank[users[i].Access] {
+					users[i].Access = access
+				}
+			}
+		}
+	}
+	return users, nil
+}
+
+func (e *MongoEngine) AddUser(ctx context.Context, opts UserOptions) (*Credentials, error) {
+	client, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := mongoCheckDatabase(ctx, client, opts.DatabaseName); err != nil {
+		return nil, err
+	}
+
+	access := opts.Access.Or(DefaultAccess("mongo"))
+	s := newSaga("mongo")
+	username, password, err := mongoCreateUser(ctx, client.Database(opts.DatabaseName), opts.Username, access, s)
+	if err != nil {
+		return nil, err
+	}
+	return &Credentials{Username: username, Password: password, DatabaseName: opts.DatabaseName, Access: access, Steps: s.Steps()}, nil
+}
+
+func (e *MongoEngine) RemoveUser(ctx context.Context, opts UserOptions) error {
+	client, err := e.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	db := client.Database(opts.DatabaseName)
+	if err := mongoCheckUser(ctx, db, opts.Username); err != nil {
+		return err
+	}
+	if err := db.RunCommand(ctx, bson.D{{Key: "dropUser", Value: opts.Username}}).Err(); err != nil {
+		return opError("mongo-drop-user", err)
+	}
+	return nil
+}
+
+func (e *MongoEngine) ResetPassword(ctx context.Context, opts UserOptions) (*Credentials, error) {
+	client, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	db := client.Database(opts.DatabaseName)
+	if err := mongoCheckUser(ctx, db, opts.Username); err != nil {
+		return nil, err
+	}
+	password, err := utils.RandomString(16)
+	if err != nil {
+		return nil, opError("mongo-create-user-random-string", err)
+	}
+	updateCmd := bson.D{
+		{Key: "updateUser", Value: opts.Username},
+		{Key: "pwd", Value: password},
+	}
+	if err := db.RunCommand(ctx, updateCmd).Err(); err != nil {
+		return nil, opError("mongo-reset-password", err)
+	}
+	return &Credentials{Username: opts.Username, Password: password, DatabaseName: opts.DatabaseName}, nil
+}
+
+func mongoCheckDatabase(ctx context.Context, client *mongo.Client, dbName string) error {
+	exists, err := mongoDatabaseExists(ctx, client, dbName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return codedError("mongo-database-not-exist", CodeNotFound, fmt.Errorf("database %s does not exist", dbName))
+	}
+	return nil
+}
+
+// mongoCheckUser makes sure username is defined in db. MongoDB users belong
+// to the database they are defined in, so no other database is affected.
+func mongoCheckUser(ctx context.Context, db *mongo.Database, username string) error {
+	users, err := mongoUsers(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if user.User == username {
+			return nil
+		}
+	}
+	return codedError("mongo-user-not-exist", CodeNotFound, fmt.Errorf("user %s is not a user of database %s", username, db.Name()))
+}
+
+// StartRotation creates another user with the roles of the user, as
+// MongoDB has a single password per user. The new user replaces the old
+// one, which is dropped when the rotation is finished.
+func (e *MongoEngine) StartRotation(ctx context.Context, opts UserOptions) (*Rotation, error) {
+	client, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	username, password, err := mongoReplaceUser(ctx, client.Database(opts.DatabaseName), opts.Username, "")
+	if err != nil {
+		return nil, err
+	}
+	return &Rotation{
+		DatabaseName:    opts.DatabaseName,
+		Username:        opts.Username,
+		NewUsername:     username,
+		Password:        password,
+		RetiredUsername: opts.Username,
+		ReplacesUser:    true,
+	}, nil
+}
+
+// mongoReplaceUser creates a user to take the place of username, with the
+// roles of access or, when access is empty, those of username.
+func mongoReplaceUser(ctx context.Context, db *mongo.Database, username string, access Access) (string, string, error) {
+	users, err := mongoUsers(ctx, db)
+	if err != nil {
+		return "", "", err
+	}
+	var roles bson.A
+	found := false
+	for _, user := range users {
+		if user.User != username {
+			continue
+		}
+		found = true
+		for _, role := range user.Roles {
+			roles = append(roles, bson.D{{Key: "role", Value: role.Role}, {Key: "db", Value: role.DB}})
+		}
+	}
+	if !found {
+		return "", "", codedError("mongo-user-not-exist", CodeNotFound, fmt.Errorf("user %s is not a user of database %s", username, db.Name()))
+	}
+	if access != "" {
+		roles = mongoUserRoles(db.Name(), access)
+	}
+
+	replacement, err := rotationUsername(username)
+	if err != nil {
+		return "", "", opError("mongo-create-user-random-string", err)
+	}
+	password, err := utils.RandomString(16)
+	if err != nil {
+		return "", "", opError("mongo-create-user-random-string", err)
+	}
+	createUserCmd := bson.D{
+		{Key: "createUser", Value: replacement},
+		{Key: "pwd", Value: password},
+		{Key: "roles", Value: roles},
+	}
+	if err := db.RunCommand(ctx, createUserCmd).Err(); err != nil {
+		return "", "", opError("mongo-create-replacement-user", err)
+	}
+	return replacement, password, nil
+}
+
+// FinishRotation drops the replaced user.
+func (e *MongoEngine) FinishRotation(ctx context.Context, rotation Rotation) error {
+	client, err := e.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	err = client.Database(rotation.DatabaseName).RunCommand(ctx, bson.D{{Key: "dropUser", Value: rotation.RetiredUsername}}).Err()
+	if err != nil && classify(err) != CodeNotFound {
+		return opError("mongo-finish-rotation", err)
+	}
+	return nil
+}
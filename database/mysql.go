@@ -1,261 +1,310 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"time"
+	"net"
+	"strings"
 
 	"github.com/bonheur15/go-db-manager/utils"
-	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
+	"github.com/go-sql-driver/mysql"
+	"github.com/rs/zerolog/log"
 )
 
-func init() {
-	validate = validator.New()
+// mysqlPrivileges are the privileges each access level grants on a
+// database.
+var mysqlPrivileges = map[Access]string{
+	AccessReadOnly:  "SELECT, SHOW VIEW",
+	AccessReadWrite: "SELECT, INSERT, UPDATE, DELETE, EXECUTE, SHOW VIEW, CREATE TEMPORARY TABLES, LOCK TABLES",
+	AccessAdmin:     "ALL PRIVILEGES",
 }
 
-func ConnectToMySQLDB(mysqlDbUser, mysqlDbPassword, mysqlDbHost, mysqlDbPort string) (*sql.DB, error) {
-	return sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%s)/", mysqlDbUser, mysqlDbPassword, mysqlDbHost, mysqlDbPort))
+var mysqlSystemDatabases = map[string]bool{
+	"information_schema": true,
+	"mysql":              true,
+	"performance_schema": true,
+	"sys":                true,
 }
 
-func MysqlCreateDatabase(c *gin.Context, mysqlDbHost, mysqlDbUser, mysqlDbPassword, mysqlDbPort string) {
-	startTime := time.Now().UnixMilli()
-	var requestBody struct {
-		DatabaseName string `json:"database_name" validate:"required,alphanum"`
-	}
-	if err := c.BindJSON(&requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-bind-json")
-		return
+func ConnectToMySQLDB(target TargetConfig) (*sql.DB, error) {
+	tlsConfig, err := target.TLS.clientConfig()
+	if err != nil {
+		return nil, err
 	}
 
-	if err := validate.Struct(requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-validation")
-		return
-	}
+	config := mysql.NewConfig()
+	config.User = target.User
+	config.Passwd = target.Password
+	config.Net = "tcp"
+	config.Addr = net.JoinHostPort(target.Host, target.Port)
+	config.TLS = tlsConfig
 
-	db, err := ConnectToMySQLDB(mysqlDbUser, mysqlDbPassword, mysqlDbHost, mysqlDbPort)
+	connector, err := mysql.NewConnector(config)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-connection-open")
-		return
+		return nil, err
 	}
-	defer db.Close()
+	return sql.OpenDB(connector), nil
+}
 
-	if _, err := db.Exec("CREATE DATABASE `" + requestBody.DatabaseName + "`"); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-create-database")
-		return
-	}
+type MySQLEngine struct {
+	target   string
+	defaults CreationOptions
+	pool     *sqlPool
+}
 
-	username, err := utils.RandomString(12)
-	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-create-user-random-string")
-		return
-	}
-	password, err := utils.RandomString(16)
-	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-create-user-random-string")
-		return
-	}
-	if _, err := db.Exec("CREATE USER ?@'%' IDENTIFIED BY ?", username, password); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-create-user")
-		return
-	}
+func NewMySQLEngine(target TargetConfig) (*MySQLEngine, error) {
+	return &MySQLEngine{
+		target:   target.Name,
+		defaults: target.CreateDefaults,
+		pool: newSQLPool("mysql", target.Pool, func() (*sql.DB, error) {
+			return ConnectToMySQLDB(target)
+		}),
+	}, nil
+}
 
-	if _, err := db.Exec(fmt.Sprintf("GRANT ALL PRIVILEGES ON `%s`.* TO ?@'%%'", requestBody.DatabaseName), username); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-grant-privileges-user")
-		return
-	}
+func (e *MySQLEngine) Name() string { return "mysql" }
 
-	if _, err := db.Exec("FLUSH PRIVILEGES"); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-flush-privileges-user")
-		return
-	}
+func (e *MySQLEngine) Target() string { return e.target }
+
+// IsSystemDatabase ignores case, since schema names are case-insensitive
+// on some platforms.
+func (e *MySQLEngine) IsSystemDatabase(name string) bool {
+	return mysqlSystemDatabases[strings.ToLower(name)]
+}
 
-	utils.SuccessResponse(c, map[string]interface{}{
-		"username":      username,
-		"password":      password,
-		"database_name": requestBody.DatabaseName,
-	}, startTime, "mysql-create-database", "Database Created")
+func (e *MySQLEngine) Connect(ctx context.Context) error {
+	_, err := e.pool.get(ctx)
+	return err
 }
 
-func MysqlResetCredentials(c *gin.Context, mysqlDbHost, mysqlDbUser, mysqlDbPassword, mysqlDbPort string) {
-	startTime := time.Now().UnixMilli()
-	var requestBody struct {
-		DatabaseName string `json:"database_name" validate:"required,alphanum"`
+func (e *MySQLEngine) Close() error {
+	return e.pool.close()
+}
+
+func (e *MySQLEngine) Create(ctx context.Context, opts CreateOptions) (*Credentials, error) {
+	dbName := opts.DatabaseName
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
 	}
-	if err := c.BindJSON(&requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-bind-json")
-		return
+
+	options := opts.Options.withDefaults(e.defaults)
+	if err := mysqlCheckOptions(ctx, db, options); err != nil {
+		return nil, err
 	}
 
-	if err := validate.Struct(requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-validation")
-		return
+	s := newSaga("mysql")
+	err = s.run(ctx, "create-database", func(ctx context.Context) error {
+		query := "CREATE DATABASE `" + dbName + "`"
+		if options.Charset != "" {
+			query += " CHARACTER SET " + options.Charset
+		}
+		if options.Collation != "" {
+			query += " COLLATE " + options.Collation
+		}
+		_, err := db.ExecContext(ctx, query)
+		return err
+	}, func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, "DROP DATABASE IF EXISTS `"+dbName+"`")
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	db, err := ConnectToMySQLDB(mysqlDbUser, mysqlDbPassword, mysqlDbHost, mysqlDbPort)
+	access := opts.Access.Or(DefaultAccess("mysql"))
+	username, password, err := mysqlCreateUser(ctx, db, dbName, "", access, s)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-connection-open")
-		return
+		return nil, err
 	}
-	defer db.Close()
 
-	rows, err := db.Query("SELECT User FROM mysql.db WHERE Db = ?", requestBody.DatabaseName)
+	err = s.run(ctx, "flush-privileges-user", func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, "FLUSH PRIVILEGES")
+		return err
+	}, nil)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-get-existing-users")
-		return
+		return nil, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var username string
-		if err := rows.Scan(&username); err != nil {
-			utils.ErrorResponse(c, err, startTime, "mysql-scan-user")
-			return
+	return &Credentials{Username: username, Password: password, DatabaseName: dbName, Access: access, Steps: s.Steps()}, nil
+}
+
+// mysqlCheckOptions makes sure the server knows the character set and
+// collation in options, and that the collation belongs to the character
+// set.
+func mysqlCheckOptions(ctx context.Context, db *sql.DB, options CreationOptions) error {
+	var fields []FieldError
+	if options.Charset != "" {
+		var collation string
+		err := db.QueryRowContext(ctx, "SELECT DEFAULT_COLLATE_NAME FROM information_schema.CHARACTER_SETS WHERE CHARACTER_SET_NAME = ?", options.Charset).Scan(&collation)
+		if errors.Is(err, sql.ErrNoRows) {
+			fields = append(fields, FieldError{Field: "options.charset", Rule: "supported", Message: "is not a character set the server supports"})
+		} else if err != nil {
+			return opError("mysql-check-options", err)
 		}
-		if _, err := db.Exec("DROP USER ?@'%'", username); err != nil {
-			utils.ErrorResponse(c, err, startTime, "mysql-drop-user")
+	}
+	if options.Collation != "" {
+		var charset string
+		err := db.QueryRowContext(ctx, "SELECT CHARACTER_SET_NAME FROM information_schema.COLLATIONS WHERE COLLATION_NAME = ?", options.Collation).Scan(&charset)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			fields = append(fields, FieldError{Field: "options.collation", Rule: "supported", Message: "is not a collation the server supports"})
+		case err != nil:
+			return opError("mysql-check-options", err)
+		case options.Charset != "" && !strings.EqualFold(charset, options.Charset):
+			fields = append(fields, FieldError{Field: "options.collation", Rule: "supported", Message: "belongs to character set " + charset + ", not " + options.Charset})
 		}
 	}
+	if fields != nil {
+		return codedError("mysql-check-options", CodeInvalidArgument, &ValidationError{Fields: fields})
+	}
+	return nil
+}
 
-	username, err := utils.RandomString(12)
-	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-create-user-random-string")
-		return
+// mysqlCreateUser creates a user with access to dbName as steps of s, so
+// that the user is dropped again if a later step fails. The user gets a
+// random name when username is empty.
+func mysqlCreateUser(ctx context.Context, db *sql.DB, dbName, username string, access Access, s *saga) (string, string, error) {
+	var err error
+	if username == "" {
+		username, err = utils.RandomString(12)
+		if err != nil {
+			return "", "", opError("mysql-create-user-random-string", err)
+		}
 	}
 	password, err := utils.RandomString(16)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-create-user-random-string")
-		return
-	}
-	if _, err := db.Exec("CREATE USER ?@'%' IDENTIFIED BY ?", username, password); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-create-user")
-		return
+		return "", "", opError("mysql-create-user-random-string", err)
 	}
 
-	if _, err := db.Exec(fmt.Sprintf("GRANT ALL PRIVILEGES ON `%s`.* TO ?@'%%'", requestBody.DatabaseName), username); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-grant-privileges-user")
-		return
+	err = s.run(ctx, "create-user", func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, "CREATE USER ?@'%' IDENTIFIED BY ?", username, password)
+		return err
+	}, func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, "DROP USER IF EXISTS ?@'%'", username)
+		return err
+	})
+	if err != nil {
+		return "", "", err
 	}
 
-	utils.SuccessResponse(c, map[string]interface{}{
-		"username":      username,
-		"password":      password,
-		"database_name": requestBody.DatabaseName,
-	}, startTime, "mysql-reset-credentials", "Database Credentials Reset")
+	err = s.run(ctx, "grant-privileges-user", func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, fmt.Sprintf("GRANT %s ON `%s`.* TO ?@'%%'", mysqlPrivileges[access], dbName), username)
+		return err
+	}, nil)
+	if err != nil {
+		return "", "", err
+	}
+	return username, password, nil
 }
 
-func MysqlRenameDatabase(c *gin.Context, mysqlDbHost, mysqlDbUser, mysqlDbPassword, mysqlDbPort string) {
-	startTime := time.Now().UnixMilli()
-	var requestBody struct {
-		OldDatabaseName string `json:"old_database_name" validate:"required,alphanum"`
-		NewDatabaseName string `json:"new_database_name" validate:"required,alphanum"`
-	}
-	if err := c.BindJSON(&requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-bind-json")
-		return
+func (e *MySQLEngine) ResetCredentials(ctx context.Context, opts ResetCredentialsOptions) (*Credentials, error) {
+	dbName := opts.DatabaseName
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := validate.Struct(requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-validation")
-		return
+	usernames := opts.Users
+	if usernames == nil {
+		usernames, err = mysqlDatabaseUsers(ctx, db, dbName)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	db, err := ConnectToMySQLDB(mysqlDbUser, mysqlDbPassword, mysqlDbHost, mysqlDbPort)
+	// The replacement is created first, so that a failure leaves the old
+	// users working.
+	access := opts.Access.Or(DefaultAccess("mysql"))
+	username, password, err := mysqlCreateUser(ctx, db, dbName, "", access, newSaga("mysql"))
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-connection-open")
-		return
+		return nil, err
 	}
-	defer db.Close()
 
-	if _, err := db.Exec(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", requestBody.NewDatabaseName)); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-create-new-database")
-		return
+	creds := &Credentials{Username: username, Password: password, DatabaseName: dbName, Access: access}
+	if opts.KeepUsers {
+		creds.Replaced = usernames
+		return creds, nil
 	}
+	for _, old := range usernames {
+		if _, err := db.ExecContext(ctx, "DROP USER ?@'%'", old); err != nil {
+			// Continue to try and drop other users
+			log.Error().Err(err).Str("action", "mysql-drop-user").Msg(err.Error())
+		}
+	}
+	return creds, nil
+}
 
-	rows, err := db.Query(fmt.Sprintf("SHOW TABLES FROM `%s`", requestBody.OldDatabaseName))
+// mysqlDatabaseUsers looks up the users with database-level grants on
+// dbName, for databases the catalog does not know about.
+func mysqlDatabaseUsers(ctx context.Context, db *sql.DB, dbName string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT User FROM mysql.db WHERE Db = ?", dbName)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-show-tables")
-		return
+		return nil, opError("mysql-get-existing-users", err)
 	}
 	defer rows.Close()
 
-	var tableName string
+	var usernames []string
 	for rows.Next() {
-		err := rows.Scan(&tableName)
-		if err != nil {
-			utils.ErrorResponse(c, err, startTime, "mysql-scan-table-name")
-			return
-		}
-		if _, err := db.Exec(fmt.Sprintf("RENAME TABLE `%s`.`%s` TO `%s`.`%s`",
-			requestBody.OldDatabaseName, tableName, requestBody.NewDatabaseName, tableName)); err != nil {
-			utils.ErrorResponse(c, err, startTime, "mysql-rename-table")
-			return
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, opError("mysql-scan-user", err)
 		}
+		usernames = append(usernames, username)
 	}
-
-	if _, err := db.Exec(fmt.Sprintf("DROP DATABASE `%s`", requestBody.OldDatabaseName)); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-drop-old-database")
-		return
+	if err := rows.Err(); err != nil {
+		return nil, opError("mysql-get-existing-users", err)
 	}
-
-	utils.SuccessResponse(c, map[string]interface{}{
-		"new_database_name": requestBody.NewDatabaseName,
-	}, startTime, "mysql-rename-database", "Database Renamed")
+	return usernames, nil
 }
 
-func MysqlDeleteDatabase(c *gin.Context, mysqlDbHost, mysqlDbUser, mysqlDbPassword, mysqlDbPort string) {
-	startTime := time.Now().UnixMilli()
-	var requestBody struct {
-		DatabaseName string `json:"database_name" validate:"required,alphanum"`
-	}
-	if err := c.BindJSON(&requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-bind-json")
-		return
-	}
+func (e *MySQLEngine) LockUsers(ctx context.Context, usernames []string) error {
+	return e.alterUsers(ctx, usernames, "ACCOUNT LOCK", "mysql-lock-user")
+}
 
-	if err := validate.Struct(requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-validation")
-		return
-	}
+func (e *MySQLEngine) UnlockUsers(ctx context.Context, usernames []string) error {
+	return e.alterUsers(ctx, usernames, "ACCOUNT UNLOCK", "mysql-unlock-user")
+}
 
-	db, err := ConnectToMySQLDB(mysqlDbUser, mysqlDbPassword, mysqlDbHost, mysqlDbPort)
+func (e *MySQLEngine) alterUsers(ctx context.Context, usernames []string, clause, action string) error {
+	db, err := e.pool.get(ctx)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-connection-open")
-		return
+		return err
 	}
-	defer db.Close()
-
-	if _, err := db.Exec(fmt.Sprintf("DROP DATABASE `%s`", requestBody.DatabaseName)); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-drop-database")
-		return
+	for _, username := range usernames {
+		if _, err := db.ExecContext(ctx, "ALTER USER ?@'%' "+clause, username); err != nil {
+			return opError(action, err)
+		}
 	}
-
-	utils.SuccessResponse(c, map[string]interface{}{
-		"database_name": requestBody.DatabaseName,
-	}, startTime, "mysql-delete-database", "Database Deleted")
+	return nil
 }
 
-func MysqlViewDatabaseStats(c *gin.Context, mysqlDbHost, mysqlDbUser, mysqlDbPassword, mysqlDbPort string) {
-	startTime := time.Now().UnixMilli()
-	var requestBody struct {
-		DatabaseName string `json:"database_name" validate:"required,alphanum"`
+func (e *MySQLEngine) Delete(ctx context.Context, opts DeleteOptions) error {
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return err
 	}
-	if err := c.BindJSON(&requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-bind-json")
-		return
+
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("DROP DATABASE `%s`", opts.DatabaseName)); err != nil {
+		return opError("mysql-drop-database", err)
 	}
 
-	if err := validate.Struct(requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-validation")
-		return
+	for _, username := range opts.Users {
+		if _, err := db.ExecContext(ctx, "DROP USER IF EXISTS ?@'%'", username); err != nil {
+			// Continue to try and drop other users
+			log.Error().Err(err).Str("action", "mysql-drop-user").Msg(err.Error())
+		}
 	}
+	return nil
+}
 
-	db, err := ConnectToMySQLDB(mysqlDbUser, mysqlDbPassword, mysqlDbHost, mysqlDbPort)
+func (e *MySQLEngine) Stats(ctx context.Context, dbName string) (*DatabaseStats, error) {
+	db, err := e.pool.get(ctx)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-connection-open")
-		return
+		return nil, err
 	}
-	defer db.Close()
 
 	query := `
 		SELECT table_name AS "Table",
@@ -264,29 +313,112 @@ func MysqlViewDatabaseStats(c *gin.Context, mysqlDbHost, mysqlDbUser, mysqlDbPas
 		WHERE TABLE_SCHEMA = ?
 		ORDER BY (data_length + index_length) DESC;
 	`
-	rows, err := db.Query(query, requestBody.DatabaseName)
+	rows, err := db.QueryContext(ctx, query, dbName)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "mysql-query-database-stats")
-		return
+		return nil, opError("mysql-query-database-stats", err)
 	}
 	defer rows.Close()
 
-	type TableStat struct {
-		Table  string  `json:"table"`
-		SizeMB float64 `json:"size_mb"`
-	}
 	var stats []TableStat
 	for rows.Next() {
 		var stat TableStat
 		if err := rows.Scan(&stat.Table, &stat.SizeMB); err != nil {
-			utils.ErrorResponse(c, err, startTime, "mysql-scan-database-stat")
-			return
+			return nil, opError("mysql-scan-database-stat", err)
 		}
 		stats = append(stats, stat)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, opError("mysql-query-database-stats", err)
+	}
+
+	return &DatabaseStats{DatabaseName: dbName, Tables: stats}, nil
+}
+
+func (e *MySQLEngine) Describe(ctx context.Context, dbName string) (*DatabaseInfo, error) {
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &DatabaseInfo{DatabaseName: dbName}
+	query := "SELECT DEFAULT_CHARACTER_SET_NAME, DEFAULT_COLLATION_NAME FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?"
+	err = db.QueryRowContext(ctx, query, dbName).Scan(&info.Encoding, &info.Collation)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, codedError("mysql-database-not-exist", CodeNotFound, fmt.Errorf("database %s does not exist", dbName))
+	}
+	if err != nil {
+		return nil, opError("mysql-describe-database", err)
+	}
+
+	query = "SELECT COALESCE(SUM(data_length + index_length), 0) FROM information_schema.TABLES WHERE TABLE_SCHEMA = ?"
+	if err := db.QueryRowContext(ctx, query, dbName).Scan(&info.SizeBytes); err != nil {
+		return nil, opError("mysql-describe-database", err)
+	}
+	query = "SELECT COUNT(*) FROM information_schema.PROCESSLIST WHERE DB = ?"
+	if err := db.QueryRowContext(ctx, query, dbName).Scan(&info.Connections); err != nil {
+		return nil, opError("mysql-describe-database", err)
+	}
+
+	if info.Users, err = mysqlDatabaseUsers(ctx, db, dbName); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (e *MySQLEngine) List(ctx context.Context) ([]string, error) {
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SHOW DATABASES")
+	if err != nil {
+		return nil, opError("mysql-list-databases", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, opError("mysql-scan-database-name", err)
+		}
+		if mysqlSystemDatabases[name] {
+			continue
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, opError("mysql-list-databases", err)
+	}
+	return names, nil
+}
+
+func (e *MySQLEngine) Usage(ctx context.Context) (*ServerUsage, error) {
+	names, err := e.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &ServerUsage{DatabaseCount: len(names)}
+	query := "SELECT COALESCE(SUM(data_length + index_length), 0) FROM information_schema.TABLES"
+	if err := db.QueryRowContext(ctx, query).Scan(&usage.UsedBytes); err != nil {
+		return nil, opError("mysql-query-usage", err)
+	}
+	var variable string
+	if err := db.QueryRowContext(ctx, "SHOW GLOBAL STATUS LIKE 'Threads_connected'").Scan(&variable, &usage.Connections); err != nil {
+		return nil, opError("mysql-query-usage", err)
+	}
+	if err := db.QueryRowContext(ctx, "SELECT @@max_connections").Scan(&usage.MaxConnections); err != nil {
+		return nil, opError("mysql-query-usage", err)
+	}
+	return usage, nil
+}
 
-	utils.SuccessResponse(c, map[string]interface{}{
-		"database_name": requestBody.DatabaseName,
-		"stats":         stats,
-	}, startTime, "mysql-view-database-stats", "Database Statistics Retrieved")
+func (e *MySQLEngine) Ping(ctx context.Context) error {
+	return e.pool.ping(ctx)
 }
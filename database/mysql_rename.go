@@ -0,0 +1,567 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// mysqlObject is a schema object that RENAME TABLE does not move and that
+// has to be recreated from its definition.
+type mysqlObject struct {
+	Kind      string // VIEW, PROCEDURE, FUNCTION, TRIGGER or EVENT
+	Name      string
+	Statement string
+	SQLMode   string
+}
+
+// mysqlSchema lists the objects of a schema by kind.
+type mysqlSchema struct {
+	Tables     []string
+	Views      []string
+	Procedures []string
+	Functions  []string
+	Triggers   []string
+	Events     []string
+}
+
+// Rename moves every table of the old schema to the new one, recreates its
+// views, routines, triggers and events there and re-points the grants on
+// it. The old schema is only dropped once everything has been moved and
+// checked; a failure before then moves everything back. Should a table
+// fail to move back, the new schema is kept with it instead of dropped.
+func (e *MySQLEngine) Rename(ctx context.Context, opts RenameOptions) (*RenameResult, error) {
+	oldName, newName := opts.OldDatabaseName, opts.NewDatabaseName
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// USE and sql_mode are session state, so the rename runs on a single
+	// connection that is not returned to the pool afterwards.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, opError("mysql-rename-connection", err)
+	}
+	defer func() {
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		conn.Close()
+	}()
+
+	var charset, collation, sqlMode string
+	query := "SELECT DEFAULT_CHARACTER_SET_NAME, DEFAULT_COLLATION_NAME FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?"
+	err = conn.QueryRowContext(ctx, query, oldName).Scan(&charset, &collation)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, codedError("mysql-database-not-exist", CodeNotFound, fmt.Errorf("database %s does not exist", oldName))
+	}
+	if err != nil {
+		return nil, opError("mysql-rename-inventory", err)
+	}
+	if err := conn.QueryRowContext(ctx, "SELECT @@SESSION.sql_mode").Scan(&sqlMode); err != nil {
+		return nil, opError("mysql-rename-inventory", err)
+	}
+
+	// Read everything up front, so that an object that cannot be read
+	// fails the rename before anything has changed.
+	schema, err := mysqlListSchema(ctx, conn, oldName)
+	if err != nil {
+		return nil, opError("mysql-rename-inventory", err)
+	}
+	views, err := mysqlShowCreateAll(ctx, conn, oldName, "VIEW", schema.Views)
+	if err != nil {
+		return nil, opError("mysql-rename-inventory", err)
+	}
+	var routines []mysqlObject
+	for _, kind := range []string{"PROCEDURE", "FUNCTION"} {
+		names := schema.Procedures
+		if kind == "FUNCTION" {
+			names = schema.Functions
+		}
+		objects, err := mysqlShowCreateAll(ctx, conn, oldName, kind, names)
+		if err != nil {
+			return nil, opError("mysql-rename-inventory", err)
+		}
+		routines = append(routines, objects...)
+	}
+	triggers, err := mysqlShowCreateAll(ctx, conn, oldName, "TRIGGER", schema.Triggers)
+	if err != nil {
+		return nil, opError("mysql-rename-inventory", err)
+	}
+	events, err := mysqlShowCreateAll(ctx, conn, oldName, "EVENT", schema.Events)
+	if err != nil {
+		return nil, opError("mysql-rename-inventory", err)
+	}
+	grants, err := mysqlSchemaGrants(ctx, conn, oldName)
+	if err != nil {
+		return nil, opError("mysql-rename-inventory", err)
+	}
+
+	s := newSaga("mysql")
+	err = s.run(ctx, "create-database", func(ctx context.Context) error {
+		_, err := conn.ExecContext(ctx, fmt.Sprintf("CREATE DATABASE `%s` CHARACTER SET %s COLLATE %s", newName, charset, collation))
+		return err
+	}, func(ctx context.Context) error {
+		// Tables that could not be moved back only exist in the new schema
+		// now, so it is kept rather than dropped with them.
+		var tables int
+		query := "SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_TYPE = 'BASE TABLE'"
+		if err := conn.QueryRowContext(ctx, query, newName).Scan(&tables); err != nil {
+			return err
+		}
+		if tables > 0 {
+			return fmt.Errorf("database %s still holds %d tables that could not be moved back to %s; it was kept", newName, tables, oldName)
+		}
+		_, err := conn.ExecContext(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS `%s`", newName))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Tables with triggers cannot be moved to another schema, so the
+	// triggers are dropped first and recreated once the tables are there.
+	var dropped []mysqlObject
+	err = s.runPartial(ctx, "drop-triggers", func(ctx context.Context) error {
+		for _, trigger := range triggers {
+			if _, err := conn.ExecContext(ctx, fmt.Sprintf("DROP TRIGGER `%s`.`%s`", oldName, trigger.Name)); err != nil {
+				return err
+			}
+			dropped = append(dropped, trigger)
+		}
+		return nil
+	}, func(ctx context.Context) error {
+		return mysqlCreateObjects(ctx, conn, oldName, dropped, sqlMode)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The tables are moved one by one; should one fail, those already
+	// moved are moved back before the new schema is dropped.
+	var moved []string
+	err = s.runPartial(ctx, "rename-tables", func(ctx context.Context) error {
+		for i, table := range schema.Tables {
+			reportProgress(ctx, i, len(schema.Tables), "renaming table "+table)
+			if _, err := conn.ExecContext(ctx, fmt.Sprintf("RENAME TABLE `%s`.`%s` TO `%s`.`%s`", oldName, table, newName, table)); err != nil {
+				return err
+			}
+			moved = append(moved, table)
+		}
+		return nil
+	}, func(ctx context.Context) error {
+		for len(moved) > 0 {
+			table := moved[len(moved)-1]
+			if _, err := conn.ExecContext(ctx, fmt.Sprintf("RENAME TABLE `%s`.`%s` TO `%s`.`%s`", newName, table, oldName, table)); err != nil {
+				return err
+			}
+			moved = moved[:len(moved)-1]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	reportProgress(ctx, len(schema.Tables), len(schema.Tables), "recreating views, routines, triggers and events")
+
+	// Definitions name the schema explicitly wherever they refer to it.
+	views = mysqlRetarget(views, oldName, newName)
+	routines = mysqlRetarget(routines, oldName, newName)
+	newTriggers := mysqlRetarget(triggers, oldName, newName)
+	events = mysqlRetarget(events, oldName, newName)
+
+	for _, step := range []struct {
+		name    string
+		objects []mysqlObject
+	}{
+		{"create-triggers", newTriggers},
+		{"create-views", views},
+		{"create-routines", routines},
+		{"create-events", events},
+	} {
+		objects := step.objects
+		// Dropping uses IF EXISTS, so it also undoes a step that created
+		// only some of the objects.
+		err = s.runPartial(ctx, step.name, func(ctx context.Context) error {
+			return mysqlCreateObjects(ctx, conn, newName, objects, sqlMode)
+		}, func(ctx context.Context) error {
+			return mysqlDropObjects(ctx, conn, newName, objects)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var granted []*mysqlGrant
+	err = s.runPartial(ctx, "grant-privileges", func(ctx context.Context) error {
+		for _, grant := range grants {
+			if _, err := conn.ExecContext(ctx, grant.grant(newName)); err != nil {
+				return err
+			}
+			granted = append(granted, grant)
+		}
+		return nil
+	}, func(ctx context.Context) error {
+		for _, grant := range granted {
+			if _, err := conn.ExecContext(ctx, grant.revoke(newName)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.run(ctx, "verify-objects", func(ctx context.Context) error {
+		return mysqlVerifyRename(ctx, conn, oldName, newName, schema)
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.run(ctx, "drop-old-database", func(ctx context.Context) error {
+		_, err := conn.ExecContext(ctx, fmt.Sprintf("DROP DATABASE `%s`", oldName))
+		return err
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Grants outlive the schema; left in place they would apply to any
+	// database created later under the old name.
+	movedGrants := make([]string, 0, len(grants))
+	for _, grant := range grants {
+		if _, err := conn.ExecContext(ctx, grant.revoke(oldName)); err != nil {
+			log.Error().Err(err).Str("action", "mysql-revoke-old-grant").Msg(err.Error())
+		}
+		movedGrants = append(movedGrants, grant.String(newName))
+	}
+
+	return &RenameResult{
+		OldDatabaseName: oldName,
+		NewDatabaseName: newName,
+		Moved: &RenamedObjects{
+			Tables:     schema.Tables,
+			Views:      schema.Views,
+			Procedures: schema.Procedures,
+			Functions:  schema.Functions,
+			Triggers:   schema.Triggers,
+			Events:     schema.Events,
+			Grants:     movedGrants,
+		},
+		Steps: s.Steps(),
+	}, nil
+}
+
+// mysqlListSchema lists the objects of schema. Triggers are listed in the
+// order they fire, so that recreating them keeps that order.
+func mysqlListSchema(ctx context.Context, conn *sql.Conn, schema string) (*mysqlSchema, error) {
+	var objects mysqlSchema
+	queries := []struct {
+		names *[]string
+		query string
+		args  []interface{}
+	}{
+		{&objects.Tables, "SELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_TYPE = 'BASE TABLE' ORDER BY TABLE_NAME", nil},
+		{&objects.Views, "SELECT TABLE_NAME FROM information_schema.VIEWS WHERE TABLE_SCHEMA = ? ORDER BY TABLE_NAME", nil},
+		{&objects.Procedures, "SELECT ROUTINE_NAME FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = ? AND ROUTINE_TYPE = ? ORDER BY ROUTINE_NAME", []interface{}{"PROCEDURE"}},
+		{&objects.Functions, "SELECT ROUTINE_NAME FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = ? AND ROUTINE_TYPE = ? ORDER BY ROUTINE_NAME", []interface{}{"FUNCTION"}},
+		{&objects.Triggers, "SELECT TRIGGER_NAME FROM information_schema.TRIGGERS WHERE TRIGGER_SCHEMA = ? ORDER BY EVENT_OBJECT_TABLE, ACTION_ORDER", nil},
+		{&objects.Events, "SELECT EVENT_NAME FROM information_schema.EVENTS WHERE EVENT_SCHEMA = ? ORDER BY EVENT_NAME", nil},
+	}
+	for _, q := range queries {
+		rows, err := conn.QueryContext(ctx, q.query, append([]interface{}{schema}, q.args...)...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			*q.names = append(*q.names, name)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return &objects, nil
+}
+
+// mysqlShowCreateAll reads the definitions of the named objects of kind.
+func mysqlShowCreateAll(ctx context.Context, conn *sql.Conn, schema, kind string, names []string) ([]mysqlObject, error) {
+	objects := make([]mysqlObject, 0, len(names))
+	for _, name := range names {
+		object, err := mysqlShowCreate(ctx, conn, schema, kind, name)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// mysqlShowCreate reads an object's definition with SHOW CREATE. The
+// result columns differ by kind, so they are picked by name.
+func mysqlShowCreate(ctx context.Context, conn *sql.Conn, schema, kind, name string) (mysqlObject, error) {
+	object := mysqlObject{Kind: kind, Name: name}
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SHOW CREATE %s `%s`.`%s`", kind, schema, name))
+	if err != nil {
+		return object, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return object, err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return object, err
+		}
+		return object, fmt.Errorf("%s %s disappeared while it was being read", strings.ToLower(kind), name)
+	}
+	values := make([]sql.NullString, len(columns))
+	targets := make([]interface{}, len(columns))
+	for i := range values {
+		targets[i] = &values[i]
+	}
+	if err := rows.Scan(targets...); err != nil {
+		return object, err
+	}
+	for i, column := range columns {
+		switch {
+		case column == "sql_mode":
+			object.SQLMode = values[i].String
+		case strings.HasPrefix(column, "Create ") || column == "SQL Original Statement":
+			object.Statement = values[i].String
+		}
+	}
+	// Routine definitions are NULL for users without the privilege to see
+	// them.
+	if object.Statement == "" {
+		return object, fmt.Errorf("cannot read the definition of %s %s", strings.ToLower(kind), name)
+	}
+	return object, nil
+}
+
+// mysqlRetarget points the schema-qualified names in the definitions at
+// newName.
+func mysqlRetarget(objects []mysqlObject, oldName, newName string) []mysqlObject {
+	retargeted := make([]mysqlObject, len(objects))
+	for i, object := range objects {
+		object.Statement = strings.ReplaceAll(object.Statement, "`"+oldName+"`.", "`"+newName+"`.")
+		retargeted[i] = object
+	}
+	return retargeted
+}
+
+// mysqlCreateObjects creates objects in schema under the sql_mode each was
+// defined with. Views can depend on each other, so objects that fail are
+// retried for as long as the others make progress.
+func mysqlCreateObjects(ctx context.Context, conn *sql.Conn, schema string, objects []mysqlObject, sqlMode string) error {
+	if len(objects) == 0 {
+		return nil
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("USE `%s`", schema)); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), "SET SESSION sql_mode = ?", sqlMode)
+
+	pending := objects
+	for len(pending) > 0 {
+		var failed []mysqlObject
+		var lastErr error
+		for _, object := range pending {
+			mode := object.SQLMode
+			if mode == "" {
+				mode = sqlMode
+			}
+			if _, err := conn.ExecContext(ctx, "SET SESSION sql_mode = ?", mode); err != nil {
+				return err
+			}
+			if _, err := conn.ExecContext(ctx, object.Statement); err != nil {
+				failed = append(failed, object)
+				lastErr = fmt.Errorf("creating %s %s: %w", strings.ToLower(object.Kind), object.Name, err)
+			}
+		}
+		if len(failed) == len(pending) {
+			return lastErr
+		}
+		pending = failed
+	}
+	return nil
+}
+
+func mysqlDropObjects(ctx context.Context, conn *sql.Conn, schema string, objects []mysqlObject) error {
+	for _, object := range objects {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("DROP %s IF EXISTS `%s`.`%s`", object.Kind, schema, object.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mysqlVerifyRename checks that no table was left behind and that every
+// view, routine, trigger and event exists in the new schema.
+func mysqlVerifyRename(ctx context.Context, conn *sql.Conn, oldName, newName string, expected *mysqlSchema) error {
+	left, err := mysqlListSchema(ctx, conn, oldName)
+	if err != nil {
+		return err
+	}
+	if len(left.Tables) > 0 {
+		return fmt.Errorf("tables left in %s: %s", oldName, strings.Join(left.Tables, ", "))
+	}
+
+	got, err := mysqlListSchema(ctx, conn, newName)
+	if err != nil {
+		return err
+	}
+	for _, kind := range []struct {
+		name          string
+		expected, got []string
+	}{
+		{"tables", expected.Tables, got.Tables},
+		{"views", expected.Views, got.Views},
+		{"procedures", expected.Procedures, got.Procedures},
+		{"functions", expected.Functions, got.Functions},
+		{"triggers", expected.Triggers, got.Triggers},
+		{"events", expected.Events, got.Events},
+	} {
+		if len(kind.got) != len(kind.expected) {
+			return fmt.Errorf("%s has %d %s after the rename, expected %d", newName, len(kind.got), kind.name, len(kind.expected))
+		}
+	}
+	return nil
+}
+
+// mysqlGrant is a grant on a schema or on an object in it, with the schema
+// left out so that it can be applied to either name.
+type mysqlGrant struct {
+	Grantee    string // 'user'@'host'
+	ObjectType string // empty, PROCEDURE or FUNCTION
+	Object     string // * for the whole schema
+	Privileges []string
+	Grantable  bool
+}
+
+func (g *mysqlGrant) on(schema string) string {
+	object := "*"
+	if g.Object != "*" {
+		object = "`" + g.Object + "`"
+	}
+	on := fmt.Sprintf("`%s`.%s", schema, object)
+	if g.ObjectType != "" {
+		on = g.ObjectType + " " + on
+	}
+	return on
+}
+
+func (g *mysqlGrant) String(schema string) string {
+	return fmt.Sprintf("%s ON %s TO %s", strings.Join(g.Privileges, ", "), g.on(schema), g.Grantee)
+}
+
+func (g *mysqlGrant) grant(schema string) string {
+	statement := "GRANT " + g.String(schema)
+	if g.Grantable {
+		statement += " WITH GRANT OPTION"
+	}
+	return statement
+}
+
+func (g *mysqlGrant) revoke(schema string) string {
+	privileges := g.Privileges
+	if g.Grantable {
+		privileges = append(privileges[:len(privileges):len(privileges)], "GRANT OPTION")
+	}
+	return fmt.Sprintf("REVOKE %s ON %s FROM %s", strings.Join(privileges, ", "), g.on(schema), g.Grantee)
+}
+
+// mysqlSchemaGrants collects the schema, table, column and routine grants
+// on schema, one per grantee and object.
+func mysqlSchemaGrants(ctx context.Context, conn *sql.Conn, schema string) ([]*mysqlGrant, error) {
+	var grants []*mysqlGrant
+	byKey := make(map[string]*mysqlGrant)
+	add := func(grantee, objectType, object, privilege string, grantable bool) {
+		key := grantee + "\x00" + objectType + "\x00" + object
+		grant, ok := byKey[key]
+		if !ok {
+			grant = &mysqlGrant{Grantee: grantee, ObjectType: objectType, Object: object}
+			byKey[key] = grant
+			grants = append(grants, grant)
+		}
+		grant.Privileges = append(grant.Privileges, privilege)
+		grant.Grantable = grant.Grantable || grantable
+	}
+
+	queries := []struct {
+		query string
+		scan  func(rows *sql.Rows) error
+	}{
+		{"SELECT GRANTEE, PRIVILEGE_TYPE, IS_GRANTABLE FROM information_schema.SCHEMA_PRIVILEGES WHERE TABLE_SCHEMA = ?", func(rows *sql.Rows) error {
+			var grantee, privilege, grantable string
+			if err := rows.Scan(&grantee, &privilege, &grantable); err != nil {
+				return err
+			}
+			add(grantee, "", "*", privilege, grantable == "YES")
+			return nil
+		}},
+		{"SELECT GRANTEE, TABLE_NAME, PRIVILEGE_TYPE, IS_GRANTABLE FROM information_schema.TABLE_PRIVILEGES WHERE TABLE_SCHEMA = ?", func(rows *sql.Rows) error {
+			var grantee, table, privilege, grantable string
+			if err := rows.Scan(&grantee, &table, &privilege, &grantable); err != nil {
+				return err
+			}
+			add(grantee, "", table, privilege, grantable == "YES")
+			return nil
+		}},
+		{"SELECT GRANTEE, TABLE_NAME, COLUMN_NAME, PRIVILEGE_TYPE, IS_GRANTABLE FROM information_schema.COLUMN_PRIVILEGES WHERE TABLE_SCHEMA = ?", func(rows *sql.Rows) error {
+			var grantee, table, column, privilege, grantable string
+			if err := rows.Scan(&grantee, &table, &column, &privilege, &grantable); err != nil {
+				return err
+			}
+			add(grantee, "", table, fmt.Sprintf("%s (`%s`)", privilege, column), grantable == "YES")
+			return nil
+		}},
+		// Routine grants are not in information_schema.
+		{"SELECT User, Host, Routine_type, Routine_name, Proc_priv FROM mysql.procs_priv WHERE Db = ?", func(rows *sql.Rows) error {
+			var user, host, routineType, routine, privileges string
+			if err := rows.Scan(&user, &host, &routineType, &routine, &privileges); err != nil {
+				return err
+			}
+			grantee := fmt.Sprintf("'%s'@'%s'", strings.ReplaceAll(user, "'", "''"), strings.ReplaceAll(host, "'", "''"))
+			// Proc_priv is a SET such as "Execute,Alter Routine,Grant".
+			grantable := strings.Contains(","+privileges+",", ",Grant,")
+			for _, privilege := range strings.Split(privileges, ",") {
+				if privilege != "" && privilege != "Grant" {
+					add(grantee, routineType, routine, strings.ToUpper(privilege), grantable)
+				}
+			}
+			return nil
+		}},
+	}
+	for _, q := range queries {
+		rows, err := conn.QueryContext(ctx, q.query, schema)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			if err := q.scan(rows); err != nil {
+				rows.Close()
+				return nil, err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return grants, nil
+}
@@ -0,0 +1,205 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bonheur15/go-db-manager/utils"
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlCheckDatabase fails with CodeNotFound when dbName does not exist.
+// GRANT does not check, so users could otherwise be added to nothing.
+func mysqlCheckDatabase(ctx context.Context, db *sql.DB, dbName string) error {
+	var name string
+	err := db.QueryRowContext(ctx, "SELECT SCHEMA_NAME FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?", dbName).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return codedError("mysql-database-not-exist", CodeNotFound, fmt.Errorf("database %s does not exist", dbName))
+	}
+	if err != nil {
+		return opError("mysql-check-database", err)
+	}
+	return nil
+}
+
+// Users lists the users with database-level grants on dbName. Their access
+// level is read back from the grants: CREATE means admin, INSERT
+// read-write and SELECT read-only.
+func (e *MySQLEngine) Users(ctx context.Context, dbName string) ([]DatabaseUser, error) {
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := mysqlCheckDatabase(ctx, db, dbName); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT User, Select_priv, Insert_priv, Create_priv FROM mysql.db WHERE Db = ? AND Host = '%' ORDER BY User", dbName)
+	if err != nil {
+		return nil, opError("mysql-get-existing-users", err)
+	}
+	defer rows.Close()
+
+	users := []DatabaseUser{}
+	for rows.Next() {
+		var user DatabaseUser
+		var selectPriv, insertPriv, createPriv string
+		if err := rows.Scan(&user.Username, &selectPriv, &insertPriv, &createPriv); err != nil {
+			return nil, opError("mysql-scan-user", err)
+		}
+		switch {
+		case createPriv == "Y":
+			user.Access = AccessAdmin
+		case insertPriv == "Y":
+			user.Access = AccessReadWrite
+		case selectPriv == "Y":
+			user.Access = AccessReadOnly
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, opError("mysql-get-existing-users", err)
+	}
+	return users, nil
+}
+
+func (e *MySQLEngine) AddUser(ctx context.Context, opts UserOptions) (*Credentials, error) {
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := mysqlCheckDatabase(ctx, db, opts.DatabaseName); err != nil {
+		return nil, err
+	}
+
+	access := opts.Access.Or(DefaultAccess("mysql"))
+	s := newSaga("mysql")
+	username, password, err := mysqlCreateUser(ctx, db, opts.DatabaseName, opts.Username, access, s)
+	if err != nil {
+		return nil, err
+	}
+	return &Credentials{Username: username, Password: password, DatabaseName: opts.DatabaseName, Access: access, Steps: s.Steps()}, nil
+}
+
+func (e *MySQLEngine) RemoveUser(ctx context.Context, opts UserOptions) error {
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	if err := mysqlCheckUser(ctx, db, opts.DatabaseName, opts.Username); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, "DROP USER ?@'%'", opts.Username); err != nil {
+		return opError("mysql-drop-user", err)
+	}
+	return nil
+}
+
+func (e *MySQLEngine) ResetPassword(ctx context.Context, opts UserOptions) (*Credentials, error) {
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := mysqlCheckUser(ctx, db, opts.DatabaseName, opts.Username); err != nil {
+		return nil, err
+	}
+	password, err := utils.RandomString(16)
+	if err != nil {
+		return nil, opError("mysql-create-user-random-string", err)
+	}
+	if _, err := db.ExecContext(ctx, "ALTER USER ?@'%' IDENTIFIED BY ?", opts.Username, password); err != nil {
+		return nil, opError("mysql-reset-password", err)
+	}
+	return &Credentials{Username: opts.Username, Password: password, DatabaseName: opts.DatabaseName}, nil
+}
+
+// mysqlCheckUser makes sure username is a user of dbName and of no other
+// database, so that it can be changed or dropped through dbName.
+func mysqlCheckUser(ctx context.Context, db *sql.DB, dbName, username string) error {
+	rows, err := db.QueryContext(ctx, "SELECT Db FROM mysql.db WHERE User = ? AND Host = '%'", username)
+	if err != nil {
+		return opError("mysql-check-user", err)
+	}
+	defer rows.Close()
+
+	found := false
+	var others []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return opError("mysql-check-user", err)
+		}
+		if name == dbName {
+			found = true
+		} else {
+			others = append(others, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return opError("mysql-check-user", err)
+	}
+	if !found {
+		return codedError("mysql-user-not-exist", CodeNotFound, fmt.Errorf("user %s is not a user of database %s", username, dbName))
+	}
+	if len(others) > 0 {
+		return codedError("mysql-check-user", CodeConflict, fmt.Errorf("user %s also has access to %s", username, strings.Join(others, ", ")))
+	}
+	return nil
+}
+
+// StartRotation gives the user a second password with RETAIN CURRENT
+// PASSWORD, which needs MySQL 8.0.14 or later.
+func (e *MySQLEngine) StartRotation(ctx context.Context, opts UserOptions) (*Rotation, error) {
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := mysqlCheckUser(ctx, db, opts.DatabaseName, opts.Username); err != nil {
+		return nil, err
+	}
+	password, err := utils.RandomString(16)
+	if err != nil {
+		return nil, opError("mysql-create-user-random-string", err)
+	}
+	_, err = db.ExecContext(ctx, "ALTER USER ?@'%' IDENTIFIED BY ? RETAIN CURRENT PASSWORD", opts.Username, password)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1064 { // ER_PARSE_ERROR
+		return nil, codedError("mysql-start-rotation", CodeInvalidArgument, errors.New("the server does not support dual passwords, which need MySQL 8.0.14 or later"))
+	}
+	if err != nil {
+		return nil, opError("mysql-start-rotation", err)
+	}
+	return &Rotation{
+		DatabaseName:    opts.DatabaseName,
+		Username:        opts.Username,
+		NewUsername:     opts.Username,
+		Password:        password,
+		RetiredUsername: opts.Username,
+	}, nil
+}
+
+// FinishRotation discards the user's old password, or drops the retired
+// user when a reset replaced it with another user.
+func (e *MySQLEngine) FinishRotation(ctx context.Context, rotation Rotation) error {
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	if rotation.ReplacesUser {
+		if _, err := db.ExecContext(ctx, "DROP USER IF EXISTS ?@'%'", rotation.RetiredUsername); err != nil {
+			return opError("mysql-finish-rotation", err)
+		}
+		return nil
+	}
+	if _, err := db.ExecContext(ctx, "ALTER USER ?@'%' DISCARD OLD PASSWORD", rotation.RetiredUsername); err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1396 { // ER_CANNOT_USER: the user is gone
+			return nil
+		}
+		return opError("mysql-finish-rotation", err)
+	}
+	return nil
+}
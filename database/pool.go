@@ -0,0 +1,199 @@
+package database
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PoolConfig controls the long-lived connection pool each engine keeps open
+// to its server. Zero values leave the driver defaults in place.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// PingTimeout bounds the ping made when a pool is (re)opened.
+	PingTimeout time.Duration
+}
+
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		MaxOpenConns:    10,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: 30 * time.Minute,
+		ConnMaxIdleTime: 5 * time.Minute,
+		PingTimeout:     5 * time.Second,
+	}
+}
+
+func (c PoolConfig) pingContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.PingTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.PingTimeout)
+}
+
+// sqlPool shares one *sql.DB between all requests for an engine. The pool
+// is opened on first use, and dropped when a ping fails so that the next
+// request reconnects.
+type sqlPool struct {
+	engine string
+	open   func() (*sql.DB, error)
+	config PoolConfig
+
+	mu sync.Mutex
+	db *sql.DB
+}
+
+func newSQLPool(engine string, config PoolConfig, open func() (*sql.DB, error)) *sqlPool {
+	return &sqlPool{engine: engine, open: open, config: config}
+}
+
+func (p *sqlPool) get(ctx context.Context) (*sql.DB, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.db != nil {
+		return p.db, nil
+	}
+
+	db, err := p.open()
+	if err != nil {
+		return nil, codedError(p.engine+"-connection-open", CodeUpstreamUnavailable, err)
+	}
+	db.SetMaxOpenConns(p.config.MaxOpenConns)
+	db.SetMaxIdleConns(p.config.MaxIdleConns)
+	db.SetConnMaxLifetime(p.config.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(p.config.ConnMaxIdleTime)
+
+	pingCtx, cancel := p.config.pingContext(ctx)
+	defer cancel()
+	if err := db.PingContext(pingCtx); err != nil {
+		db.Close()
+		return nil, codedError(p.engine+"-connection-open", CodeUpstreamUnavailable, err)
+	}
+
+	p.db = db
+	return db, nil
+}
+
+func (p *sqlPool) ping(ctx context.Context) error {
+	db, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		p.discard(db)
+		return codedError(p.engine+"-ping", CodeUpstreamUnavailable, err)
+	}
+	return nil
+}
+
+func (p *sqlPool) discard(db *sql.DB) {
+	p.mu.Lock()
+	if p.db == db {
+		p.db = nil
+	}
+	p.mu.Unlock()
+	db.Close()
+}
+
+func (p *sqlPool) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.db == nil {
+		return nil
+	}
+	err := p.db.Close()
+	p.db = nil
+	return err
+}
+
+// mongoPool is the MongoDB counterpart of sqlPool; the driver keeps its own
+// connection pool inside the client.
+type mongoPool struct {
+	uri    string
+	tls    *tls.Config
+	config PoolConfig
+
+	mu     sync.Mutex
+	client *mongo.Client
+}
+
+func newMongoPool(uri string, tlsConfig *tls.Config, config PoolConfig) *mongoPool {
+	return &mongoPool{uri: uri, tls: tlsConfig, config: config}
+}
+
+func (p *mongoPool) get(ctx context.Context) (*mongo.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.client != nil {
+		return p.client, nil
+	}
+
+	clientOptions := options.Client().ApplyURI(p.uri)
+	if p.tls != nil {
+		clientOptions.SetTLSConfig(p.tls)
+	}
+	if p.config.MaxOpenConns > 0 {
+		clientOptions.SetMaxPoolSize(uint64(p.config.MaxOpenConns))
+	}
+	if p.config.ConnMaxIdleTime > 0 {
+		clientOptions.SetMaxConnIdleTime(p.config.ConnMaxIdleTime)
+	}
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, codedError("mongo-connection-open", CodeUpstreamUnavailable, err)
+	}
+
+	pingCtx, cancel := p.config.pingContext(ctx)
+	defer cancel()
+	if err := client.Ping(pingCtx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, codedError("mongo-connection-open", CodeUpstreamUnavailable, err)
+	}
+
+	p.client = client
+	return client, nil
+}
+
+func (p *mongoPool) ping(ctx context.Context) error {
+	client, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		p.discard(client)
+		return codedError("mongo-ping", CodeUpstreamUnavailable, err)
+	}
+	return nil
+}
+
+func (p *mongoPool) discard(client *mongo.Client) {
+	p.mu.Lock()
+	if p.client == client {
+		p.client = nil
+	}
+	p.mu.Unlock()
+	client.Disconnect(context.Background())
+}
+
+func (p *mongoPool) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.client == nil {
+		return nil
+	}
+	err := p.client.Disconnect(context.Background())
+	p.client = nil
+	return err
+}
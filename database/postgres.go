@@ -1,182 +1,424 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/bonheur15/go-db-manager/utils"
-	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
 	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
 )
 
-func init() {
-	validate = validator.New()
+var postgresSystemDatabases = map[string]bool{
+	"postgres":  true,
+	"template0": true,
+	"template1": true,
 }
 
-func ConnectToPostgresDB(postgresDbUser, postgresDbPassword, postgresDbHost, postgresDbPort, sslMode string) (*sql.DB, error) {
-	connStr := fmt.Sprintf("user=%s password=%s host=%s port=%s sslmode=%s", postgresDbUser, postgresDbPassword, postgresDbHost, postgresDbPort, sslMode)
-	return sql.Open("postgres", connStr)
+func ConnectToPostgresDB(target TargetConfig) (*sql.DB, error) {
+	return postgresConnect(target, "")
 }
 
-func PostgresCreateDatabase(c *gin.Context, postgresDbHost, postgresDbUser, postgresDbPassword, postgresDbPort, sslMode string) {
-	startTime := time.Now().UnixMilli()
-	var requestBody struct {
-		DatabaseName string `json:"database_name" validate:"required,alphanum"`
+// postgresConnect opens a handle on dbName, or on the user's default
+// database when dbName is empty.
+func postgresConnect(target TargetConfig, dbName string) (*sql.DB, error) {
+	params := []string{
+		"user=" + postgresConnValue(target.User),
+		"password=" + postgresConnValue(target.Password),
+		"host=" + postgresConnValue(target.Host),
+		"port=" + postgresConnValue(target.Port),
 	}
-	if err := c.BindJSON(&requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-bind-json")
-		return
+	if dbName != "" {
+		params = append(params, "dbname="+postgresConnValue(dbName))
 	}
-
-	if err := validate.Struct(requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-validation")
-		return
+	// Without an sslmode lib/pq falls back to "require".
+	if target.TLS.Mode != "" {
+		params = append(params, "sslmode="+postgresConnValue(target.TLS.Mode))
+	}
+	if target.TLS.CAFile != "" {
+		params = append(params, "sslrootcert="+postgresConnValue(target.TLS.CAFile))
+	}
+	if target.TLS.CertFile != "" {
+		params = append(params, "sslcert="+postgresConnValue(target.TLS.CertFile))
 	}
+	if target.TLS.KeyFile != "" {
+		params = append(params, "sslkey="+postgresConnValue(target.TLS.KeyFile))
+	}
+	return sql.Open("postgres", strings.Join(params, " "))
+}
+
+// postgresConnValue quotes a keyword/value connection string value.
+func postgresConnValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+type PostgresEngine struct {
+	target   string
+	defaults CreationOptions
+	pool     *sqlPool
+	// config opens connections to single databases, for statements such as
+	// schema grants that only apply to the database they run in.
+	config TargetConfig
+}
+
+func NewPostgresEngine(target TargetConfig) (*PostgresEngine, error) {
+	return &PostgresEngine{
+		target:   target.Name,
+		defaults: target.CreateDefaults,
+		config:   target,
+		pool: newSQLPool("postgres", target.Pool, func() (*sql.DB, error) {
+			return ConnectToPostgresDB(target)
+		}),
+	}, nil
+}
 
-	db, err := ConnectToPostgresDB(postgresDbUser, postgresDbPassword, postgresDbHost, postgresDbPort, sslMode)
+func (e *PostgresEngine) Name() string { return "postgres" }
+
+func (e *PostgresEngine) Target() string { return e.target }
+
+func (e *PostgresEngine) IsSystemDatabase(name string) bool {
+	return postgresSystemDatabases[name]
+}
+
+func (e *PostgresEngine) Connect(ctx context.Context) error {
+	_, err := e.pool.get(ctx)
+	return err
+}
+
+func (e *PostgresEngine) Close() error {
+	return e.pool.close()
+}
+
+func (e *PostgresEngine) Create(ctx context.Context, opts CreateOptions) (*Credentials, error) {
+	dbName := opts.DatabaseName
+	db, err := e.pool.get(ctx)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-connection-open")
-		return
+		return nil, err
 	}
-	defer db.Close()
 
-	if _, err := db.Exec(fmt.Sprintf("CREATE DATABASE %s", pq.QuoteIdentifier(requestBody.DatabaseName))); err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-create-database")
-		return
+	options := opts.Options.withDefaults(e.defaults)
+	if err := postgresCheckOptions(ctx, db, options); err != nil {
+		return nil, err
 	}
 
-	username, err := utils.RandomString(12)
-	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-create-user-random-string")
-		return
-	}
-	password, err := utils.RandomString(16)
+	s := newSaga("postgres")
+	err = s.run(ctx, "create-database", func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, "CREATE DATABASE "+pq.QuoteIdentifier(dbName)+postgresCreateClauses(options))
+		return err
+	}, func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS %s", pq.QuoteIdentifier(dbName)))
+		return err
+	})
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-create-user-random-string")
-		return
+		return nil, err
 	}
-	createUserQuery := fmt.Sprintf("CREATE USER %s WITH PASSWORD %s", pq.QuoteIdentifier(username), pq.QuoteLiteral(password))
-	_, err = db.Exec(createUserQuery)
+
+	access := opts.Access.Or(DefaultAccess("postgres"))
+	username, password, err := e.createUser(ctx, db, dbName, "", access, s)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-create-user")
-		return
+		return nil, err
 	}
 
-	grantQuery := fmt.Sprintf("GRANT ALL PRIVILEGES ON DATABASE %s TO %s", pq.QuoteIdentifier(requestBody.DatabaseName), pq.QuoteIdentifier(username))
-	if _, err := db.Exec(grantQuery); err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-grant-privileges-user")
-		return
+	return &Credentials{Username: username, Password: password, DatabaseName: dbName, Access: access, Steps: s.Steps()}, nil
+}
+
+// postgresCreateClauses renders the options of CREATE DATABASE.
+func postgresCreateClauses(options CreationOptions) string {
+	var clauses strings.Builder
+	if options.Encoding != "" {
+		clauses.WriteString(" ENCODING " + pq.QuoteLiteral(options.Encoding))
+	}
+	if options.LCCollate != "" {
+		clauses.WriteString(" LC_COLLATE " + pq.QuoteLiteral(options.LCCollate))
 	}
+	if options.LCCtype != "" {
+		clauses.WriteString(" LC_CTYPE " + pq.QuoteLiteral(options.LCCtype))
+	}
+	if options.Template != "" {
+		clauses.WriteString(" TEMPLATE " + pq.QuoteIdentifier(options.Template))
+	}
+	if options.Tablespace != "" {
+		clauses.WriteString(" TABLESPACE " + pq.QuoteIdentifier(options.Tablespace))
+	}
+	if options.ConnectionLimit != nil {
+		clauses.WriteString(fmt.Sprintf(" CONNECTION LIMIT %d", *options.ConnectionLimit))
+	}
+	return clauses.String()
+}
 
-	utils.SuccessResponse(c, map[string]interface{}{
-		"username":      username,
-		"password":      password,
-		"database_name": requestBody.DatabaseName,
-	}, startTime, "postgres-create-database", "Database Created")
+// postgresCheckOptions makes sure the server knows the encoding and
+// tablespace in options, and that the template is a template database.
+// Locales are left to CREATE DATABASE, which checks them against the
+// operating system and the template's encoding.
+func postgresCheckOptions(ctx context.Context, db *sql.DB, options CreationOptions) error {
+	var fields []FieldError
+	if options.Encoding != "" {
+		var valid bool
+		if err := db.QueryRowContext(ctx, "SELECT pg_char_to_encoding($1) >= 0", options.Encoding).Scan(&valid); err != nil {
+			return opError("postgres-check-options", err)
+		}
+		if !valid {
+			fields = append(fields, FieldError{Field: "options.encoding", Rule: "supported", Message: "is not an encoding the server supports"})
+		}
+	}
+	if options.Template != "" {
+		var isTemplate bool
+		err := db.QueryRowContext(ctx, "SELECT datistemplate FROM pg_database WHERE datname = $1", options.Template).Scan(&isTemplate)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			fields = append(fields, FieldError{Field: "options.template", Rule: "supported", Message: "does not exist"})
+		case err != nil:
+			return opError("postgres-check-options", err)
+		case !isTemplate:
+			fields = append(fields, FieldError{Field: "options.template", Rule: "supported", Message: "is not a template database"})
+		}
+	}
+	if options.Tablespace != "" {
+		var exists bool
+		if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_tablespace WHERE spcname = $1)", options.Tablespace).Scan(&exists); err != nil {
+			return opError("postgres-check-options", err)
+		}
+		if !exists {
+			fields = append(fields, FieldError{Field: "options.tablespace", Rule: "supported", Message: "does not exist"})
+		}
+	}
+	if fields != nil {
+		return codedError("postgres-check-options", CodeInvalidArgument, &ValidationError{Fields: fields})
+	}
+	return nil
 }
 
-func PostgresResetCredentials(c *gin.Context, postgresDbHost, postgresDbUser, postgresDbPassword, postgresDbPort, sslMode string) {
-	startTime := time.Now().UnixMilli()
-	var requestBody struct {
-		DatabaseName string `json:"database_name" validate:"required,alphanum"`
+// createUser creates a user with access to dbName as steps of s, so that
+// the user is dropped again if a later step fails. The user gets a random
+// name when username is empty.
+func (e *PostgresEngine) createUser(ctx context.Context, db *sql.DB, dbName, username string, access Access, s *saga) (string, string, error) {
+	var err error
+	if username == "" {
+		username, err = utils.RandomString(12)
+		if err != nil {
+			return "", "", opError("postgres-create-user-random-string", err)
+		}
+	}
+	password, err := utils.RandomString(16)
+	if err != nil {
+		return "", "", opError("postgres-create-user-random-string", err)
 	}
-	if err := c.BindJSON(&requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-bind-json")
-		return
+	// The users already there share the tables they create with the new
+	// user, and an admin shares its tables with them.
+	users, err := postgresDatabaseAccess(ctx, db, dbName)
+	if err != nil {
+		return "", "", err
 	}
+	grants := postgresAccessGrants[access]
 
-	if err := validate.Struct(requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-validation")
-		return
+	err = s.run(ctx, "create-user", func(ctx context.Context) error {
+		createUserQuery := fmt.Sprintf("CREATE USER %s WITH PASSWORD %s", pq.QuoteIdentifier(username), pq.QuoteLiteral(password))
+		_, err := db.ExecContext(ctx, createUserQuery)
+		return err
+	}, func(ctx context.Context) error {
+		// Whatever the role was granted or came to own in dbName would
+		// keep DROP ROLE from succeeding.
+		return e.dropUser(ctx, db, dbName, username, "CURRENT_USER")
+	})
+	if err != nil {
+		return "", "", err
 	}
 
-	db, err := ConnectToPostgresDB(postgresDbUser, postgresDbPassword, postgresDbHost, postgresDbPort, sslMode)
+	err = s.run(ctx, "grant-privileges-user", func(ctx context.Context) error {
+		grantQuery := fmt.Sprintf("GRANT %s ON DATABASE %s TO %s", grants.database, pq.QuoteIdentifier(dbName), pq.QuoteIdentifier(username))
+		_, err := db.ExecContext(ctx, grantQuery)
+		return err
+	}, func(ctx context.Context) error {
+		// The role cannot be dropped while it holds privileges.
+		revokeQuery := fmt.Sprintf("REVOKE ALL PRIVILEGES ON DATABASE %s FROM %s", pq.QuoteIdentifier(dbName), pq.QuoteIdentifier(username))
+		_, err := db.ExecContext(ctx, revokeQuery)
+		return err
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	// inDatabase runs the statements in one transaction, so the step is
+	// atomic.
+	err = s.run(ctx, "grant-schema-privileges", func(ctx context.Context) error {
+		statements := grants.objects(username)
+		for _, user := range users {
+			if user.access == AccessAdmin {
+				statements = append(statements, grants.defaults(user.name, username)...)
+			}
+			if access == AccessAdmin {
+				statements = append(statements, postgresAccessGrants[user.access].defaults(username, user.name)...)
+			}
+		}
+		return e.inDatabase(ctx, dbName, statements...)
+	}, func(ctx context.Context) error {
+		return e.inDatabase(ctx, dbName, "DROP OWNED BY "+pq.QuoteIdentifier(username))
+	})
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-connection-open")
-		return
+		return "", "", err
 	}
-	defer db.Close()
+	return username, password, nil
+}
 
-	rows, err := db.Query("SELECT usename FROM pg_user WHERE usesysid IN (SELECT usesysid FROM pg_database WHERE datname = $1)", requestBody.DatabaseName)
+func (e *PostgresEngine) ResetCredentials(ctx context.Context, opts ResetCredentialsOptions) (*Credentials, error) {
+	dbName := opts.DatabaseName
+	db, err := e.pool.get(ctx)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-get-existing-users")
-		return
+		return nil, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var username string
-		if err := rows.Scan(&username); err != nil {
-			utils.ErrorResponse(c, err, startTime, "postgres-scan-user")
-			return
+	usernames := opts.Users
+	if usernames == nil {
+		usernames, err = postgresDatabaseUsers(ctx, db, dbName)
+		if err != nil {
+			return nil, err
 		}
-		if _, err := db.Exec(fmt.Sprintf("DROP USER %s", pq.QuoteIdentifier(username))); err != nil {
-			utils.ErrorResponse(c, err, startTime, "postgres-drop-user")
+	}
+
+	// The replacement is created first, so that it can take over what the
+	// old users own.
+	access := opts.Access.Or(DefaultAccess("postgres"))
+	username, password, err := e.createUser(ctx, db, dbName, "", access, newSaga("postgres"))
+	if err != nil {
+		return nil, err
+	}
+	creds := &Credentials{Username: username, Password: password, DatabaseName: dbName, Access: access}
+	if opts.KeepUsers {
+		creds.Replaced = usernames
+		return creds, nil
+	}
+	heir := postgresHeir(username, access)
+	for _, old := range usernames {
+		if err := e.dropUser(ctx, db, dbName, old, heir); err != nil {
 			// Continue to try and drop other users
+			log.Error().Err(err).Str("action", "postgres-drop-user").Msg(err.Error())
 		}
 	}
+	return creds, nil
+}
 
-	username, err := utils.RandomString(12)
+// Rename renames the database with ALTER DATABASE, which Postgres refuses
+// while anyone is connected to it. New connections are blocked first, then
+// the open sessions are terminated (opts.Force) or waited for, and
+// connections are allowed again under the new name.
+func (e *PostgresEngine) Rename(ctx context.Context, opts RenameOptions) (*RenameResult, error) {
+	oldName, newName := opts.OldDatabaseName, opts.NewDatabaseName
+	db, err := e.pool.get(ctx)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-create-user-random-string")
-		return
+		return nil, err
+	}
+
+	var allowConnections bool
+	err = db.QueryRowContext(ctx, "SELECT datallowconn FROM pg_database WHERE datname = $1", oldName).Scan(&allowConnections)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, codedError("postgres-database-not-exist", CodeNotFound, fmt.Errorf("database %s does not exist", oldName))
 	}
-	password, err := utils.RandomString(16)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-create-user-random-string")
-		return
+		return nil, opError("postgres-rename-database", err)
 	}
-	if _, err := db.Exec(fmt.Sprintf("CREATE USER %s WITH PASSWORD %s", pq.QuoteIdentifier(username), pq.QuoteLiteral(password))); err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-create-user")
-		return
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM pg_database WHERE datname = $1)", newName).Scan(&exists); err != nil {
+		return nil, opError("postgres-rename-database", err)
 	}
-
-	if _, err := db.Exec(fmt.Sprintf("GRANT ALL PRIVILEGES ON DATABASE %s TO %s", pq.QuoteIdentifier(requestBody.DatabaseName), pq.QuoteIdentifier(username))); err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-grant-privileges-user")
-		return
+	if exists {
+		return nil, codedError("postgres-database-exists", CodeConflict, fmt.Errorf("database %s already exists", newName))
 	}
 
-	utils.SuccessResponse(c, map[string]interface{}{
-		"username":      username,
-		"password":      password,
-		"database_name": requestBody.DatabaseName,
-	}, startTime, "postgres-reset-credentials", "Database Credentials Reset")
-}
+	s := newSaga("postgres")
+	if allowConnections {
+		err = s.run(ctx, "block-connections", func(ctx context.Context) error {
+			return postgresAllowConnections(ctx, db, oldName, false)
+		}, func(ctx context.Context) error {
+			return postgresAllowConnections(ctx, db, oldName, true)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
 
-func PostgresRenameDatabase(c *gin.Context, postgresDbHost, postgresDbUser, postgresDbPassword, postgresDbPort, sslMode string) {
-	startTime := time.Now().UnixMilli()
-	var requestBody struct {
-		OldDatabaseName string `json:"old_database_name" validate:"required,alphanum"`
-		NewDatabaseName string `json:"new_database_name" validate:"required,alphanum"`
+	step := "wait-for-sessions"
+	if opts.Force {
+		step = "terminate-sessions"
 	}
-	if err := c.BindJSON(&requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-bind-json")
-		return
+	err = s.run(ctx, step, func(ctx context.Context) error {
+		return postgresEndSessions(ctx, db, oldName, opts.Force, opts.Wait())
+	}, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := validate.Struct(requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-validation")
-		return
+	err = s.run(ctx, "rename-database", func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, fmt.Sprintf("ALTER DATABASE %s RENAME TO %s", pq.QuoteIdentifier(oldName), pq.QuoteIdentifier(newName)))
+		return err
+	}, func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, fmt.Sprintf("ALTER DATABASE %s RENAME TO %s", pq.QuoteIdentifier(newName), pq.QuoteIdentifier(oldName)))
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	db, err := ConnectToPostgresDB(postgresDbUser, postgresDbPassword, postgresDbHost, postgresDbPort, sslMode)
-	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-connection-open")
-		return
+	if allowConnections {
+		err = s.run(ctx, "allow-connections", func(ctx context.Context) error {
+			return postgresAllowConnections(ctx, db, newName, true)
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
 	}
-	defer db.Close()
 
-	query := fmt.Sprintf("ALTER DATABASE %s RENAME TO %s", pq.QuoteIdentifier(requestBody.OldDatabaseName), pq.QuoteIdentifier(requestBody.NewDatabaseName))
-	if _, err := db.Exec(query); err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-rename-database")
-		return
+	return &RenameResult{OldDatabaseName: oldName, NewDatabaseName: newName, Steps: s.Steps()}, nil
+}
+
+func postgresAllowConnections(ctx context.Context, db *sql.DB, dbName string, allow bool) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf("ALTER DATABASE %s WITH ALLOW_CONNECTIONS %t", pq.QuoteIdentifier(dbName), allow))
+	return err
+}
+
+// postgresEndSessions waits up to wait for the sessions on dbName to end,
+// terminating them first when terminate is set. Sessions that outlast the
+// wait are reported as a conflict.
+func postgresEndSessions(ctx context.Context, db *sql.DB, dbName string, terminate bool, wait time.Duration) error {
+	if terminate {
+		if err := PostgresTerminateConnections(db, dbName); err != nil {
+			return err
+		}
 	}
 
-	utils.SuccessResponse(c, map[string]interface{}{
-		"new_database_name": requestBody.NewDatabaseName,
-	}, startTime, "postgres-rename-database", "Database Renamed")
+	waitCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	sessions := -1
+	for {
+		query := "SELECT COUNT(*) FROM pg_stat_activity WHERE datname = $1 AND pid <> pg_backend_pid()"
+		err := db.QueryRowContext(waitCtx, query, dbName).Scan(&sessions)
+		if err != nil && waitCtx.Err() == nil {
+			return err
+		}
+		if err == nil {
+			if sessions == 0 {
+				return nil
+			}
+			reportProgress(ctx, 0, 1, fmt.Sprintf("waiting for %d sessions on %s to end", sessions, dbName))
+		}
+
+		select {
+		case <-waitCtx.Done():
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return codedError("postgres-database-in-use", CodeConflict,
+				fmt.Errorf("database %s still has %d sessions after %s; retry with force to terminate them", dbName, sessions, wait))
+		case <-ticker.C:
+		}
+	}
 }
 
 // Terminate connections to the specified database
@@ -202,109 +444,103 @@ func PostgresTerminateConnections(db *sql.DB, dbName string) error {
 		_, err := db.Exec("SELECT pg_terminate_backend($1)", pid)
 		if err != nil {
 			// Log error but continue trying to terminate other connections
+			log.Error().Err(err).Int("pid", pid).Msg("failed to terminate postgres backend")
 		}
 	}
 
-	return nil
+	return rows.Err()
 }
 
-// Handle deleting a PostgreSQL database and associated users
-func PostgresDeleteDatabase(c *gin.Context, postgresDbHost, postgresDbUser, postgresDbPassword, postgresDbPort, sslMode string) {
-	startTime := time.Now().UnixMilli()
-	var requestBody struct {
-		DatabaseName string `json:"database_name" validate:"required,alphanum"`
+// postgresDatabaseUsers looks up the users of dbName, for databases the
+// catalog does not know about.
+func postgresDatabaseUsers(ctx context.Context, db *sql.DB, dbName string) ([]string, error) {
+	users, err := postgresDatabaseAccess(ctx, db, dbName)
+	if err != nil {
+		return nil, err
 	}
-	if err := c.BindJSON(&requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-bind-json")
-		return
+	usernames := make([]string, len(users))
+	for i, user := range users {
+		usernames[i] = user.name
 	}
+	return usernames, nil
+}
+
+func (e *PostgresEngine) LockUsers(ctx context.Context, usernames []string) error {
+	return e.alterRoles(ctx, usernames, "NOLOGIN", "postgres-lock-user")
+}
+
+func (e *PostgresEngine) UnlockUsers(ctx context.Context, usernames []string) error {
+	return e.alterRoles(ctx, usernames, "LOGIN", "postgres-unlock-user")
+}
 
-	if err := validate.Struct(requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-validation")
-		return
+func (e *PostgresEngine) alterRoles(ctx context.Context, usernames []string, option, action string) error {
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return err
 	}
+	for _, username := range usernames {
+		// The alternate logins of a user in a rotation go along with it.
+		alternates, err := postgresAlternates(ctx, db, username)
+		if err != nil {
+			return err
+		}
+		for _, role := range append([]string{username}, alternates...) {
+			if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER ROLE %s %s", pq.QuoteIdentifier(role), option)); err != nil {
+				return opError(action, err)
+			}
+		}
+	}
+	return nil
+}
 
+// Delete drops a PostgreSQL database and its associated users
+func (e *PostgresEngine) Delete(ctx context.Context, opts DeleteOptions) error {
+	dbName := opts.DatabaseName
 	// Connect to the maintenance database (e.g., postgres)
-	adminDb, err := ConnectToPostgresDB(postgresDbUser, postgresDbPassword, postgresDbHost, postgresDbPort, sslMode)
+	adminDb, err := e.pool.get(ctx)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-connection-open")
-		return
+		return err
 	}
-	defer adminDb.Close()
 
 	// Terminate connections to the database associated with the user
-	if err := PostgresTerminateConnections(adminDb, requestBody.DatabaseName); err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-terminate-connections")
-		return
-	}
-	// Identify users with CONNECT privileges on the specified database
-	query := `
-		SELECT u.usename
-		FROM pg_user u
-		WHERE has_database_privilege(u.usename, $1, 'CONNECT');
-	`
-	rows, err := adminDb.Query(query, requestBody.DatabaseName)
-	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-get-existing-users")
-		return
+	if err := PostgresTerminateConnections(adminDb, dbName); err != nil {
+		return opError("postgres-terminate-connections", err)
 	}
-	defer rows.Close()
-
-	var userNames []string
-	for rows.Next() {
-		var userName string
-		if err := rows.Scan(&userName); err != nil {
-			utils.ErrorResponse(c, err, startTime, "postgres-scan-user-name")
-			return
-		}
-		// if username is postgres skip it
-		if userName == "postgres" {
-			continue
+	userNames := opts.Users
+	if userNames == nil {
+		userNames, err = postgresDatabaseUsers(ctx, adminDb, dbName)
+		if err != nil {
+			return err
 		}
-
-		userNames = append(userNames, userName)
 	}
 
 	// Drop the database
-	if _, err := adminDb.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s", pq.QuoteIdentifier(requestBody.DatabaseName))); err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-drop-database")
-		return
+	if _, err := adminDb.ExecContext(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS %s", pq.QuoteIdentifier(dbName))); err != nil {
+		return opError("postgres-drop-database", err)
 	}
 
-	// Drop each user associated with the database
+	// Drop each user associated with the database, and its alternate logins
 	for _, userName := range userNames {
-		if _, err := adminDb.Exec(fmt.Sprintf("DROP ROLE IF EXISTS %s", pq.QuoteIdentifier(userName))); err != nil {
-			utils.ErrorResponse(c, err, startTime, "postgres-drop-user")
-			// Continue to try and drop other users
+		alternates, err := postgresAlternates(ctx, adminDb, userName)
+		if err != nil {
+			log.Error().Err(err).Str("action", "postgres-get-alternates").Msg(err.Error())
+		}
+		for _, role := range append(alternates, userName) {
+			if _, err := adminDb.ExecContext(ctx, fmt.Sprintf("DROP ROLE IF EXISTS %s", pq.QuoteIdentifier(role))); err != nil {
+				// Continue to try and drop other users
+				log.Error().Err(err).Str("action", "postgres-drop-user").Msg(err.Error())
+			}
 		}
 	}
 
-	utils.SuccessResponse(c, map[string]interface{}{
-		"database_name": requestBody.DatabaseName,
-	}, startTime, "postgres-delete-database", "Database Deleted")
+	return nil
 }
 
-func PostgresViewDatabaseStats(c *gin.Context, postgresDbHost, postgresDbUser, postgresDbPassword, postgresDbPort, sslMode string) {
-	startTime := time.Now().UnixMilli()
-	var requestBody struct {
-		DatabaseName string `json:"database_name" validate:"required,alphanum"`
-	}
-	if err := c.BindJSON(&requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-bind-json")
-		return
-	}
-
-	if err := validate.Struct(requestBody); err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-validation")
-		return
-	}
-
-	db, err := ConnectToPostgresDB(postgresDbUser, postgresDbPassword, postgresDbHost, postgresDbPort, sslMode)
+func (e *PostgresEngine) Stats(ctx context.Context, dbName string) (*DatabaseStats, error) {
+	db, err := e.pool.get(ctx)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-connection-open")
-		return
+		return nil, err
 	}
-	defer db.Close()
 
 	query := `
 		SELECT table_name AS "Table",
@@ -313,42 +549,119 @@ func PostgresViewDatabaseStats(c *gin.Context, postgresDbHost, postgresDbUser, p
 		WHERE table_schema = 'public'
 		ORDER BY pg_total_relation_size(format('%%I.%%I', current_database(), table_name)) DESC;
 	`
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-query-database-stats")
-		return
+		return nil, opError("postgres-query-database-stats", err)
 	}
 	defer rows.Close()
 
-	type TableStat struct {
-		Table  string  `json:"table"`
-		SizeMB float64 `json:"size_mb"`
-	}
 	var stats []TableStat
 	for rows.Next() {
 		var stat TableStat
 		if err := rows.Scan(&stat.Table, &stat.SizeMB); err != nil {
-			utils.ErrorResponse(c, err, startTime, "postgres-scan-database-stat")
-			return
+			return nil, opError("postgres-scan-database-stat", err)
 		}
 		stats = append(stats, stat)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, opError("postgres-query-database-stats", err)
+	}
+
+	return &DatabaseStats{DatabaseName: dbName, Tables: stats}, nil
+}
+
+func (e *PostgresEngine) Describe(ctx context.Context, dbName string) (*DatabaseInfo, error) {
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &DatabaseInfo{DatabaseName: dbName}
+	query := `
+		SELECT
+			pg_database_size(datname),
+			pg_encoding_to_char(encoding),
+			datcollate,
+			(SELECT count(*) FROM pg_stat_activity WHERE datname = $1)
+		FROM pg_database
+		WHERE datname = $1
+	`
+	err = db.QueryRowContext(ctx, query, dbName).Scan(&info.SizeBytes, &info.Encoding, &info.Collation, &info.Connections)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, codedError("postgres-database-not-exist", CodeNotFound, fmt.Errorf("database %s does not exist", dbName))
+	}
+	if err != nil {
+		return nil, opError("postgres-describe-database", err)
+	}
+
+	if info.Users, err = postgresDatabaseUsers(ctx, db, dbName); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (e *PostgresEngine) List(ctx context.Context) ([]string, error) {
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT datname FROM pg_database WHERE NOT datistemplate AND datname <> 'postgres' ORDER BY datname")
+	if err != nil {
+		return nil, opError("postgres-list-databases", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, opError("postgres-scan-database-name", err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, opError("postgres-list-databases", err)
+	}
+	return names, nil
+}
+
+func (e *PostgresEngine) Usage(ctx context.Context) (*ServerUsage, error) {
+	names, err := e.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &ServerUsage{DatabaseCount: len(names)}
+	query := `
+		SELECT
+			COALESCE(SUM(pg_database_size(datname)), 0),
+			(SELECT count(*) FROM pg_stat_activity),
+			current_setting('max_connections')::int
+		FROM pg_database
+		WHERE NOT datistemplate
+	`
+	if err := db.QueryRowContext(ctx, query).Scan(&usage.UsedBytes, &usage.Connections, &usage.MaxConnections); err != nil {
+		return nil, opError("postgres-query-usage", err)
+	}
+	return usage, nil
+}
 
-	utils.SuccessResponse(c, map[string]interface{}{
-		"database_name": requestBody.DatabaseName,
-		"stats":         stats,
-	}, startTime, "postgres-view-database-stats", "Database Statistics Retrieved")
+func (e *PostgresEngine) Ping(ctx context.Context) error {
+	return e.pool.ping(ctx)
 }
 
-func PostgresGetTotalQueries(c *gin.Context, postgresDbHost, postgresDbUser, postgresDbPassword, postgresDbPort, sslMode string) {
+// TotalQueries reports per-user query counts from pg_stat_statements.
+func (e *PostgresEngine) TotalQueries(ctx context.Context) ([]QueryActivity, error) {
 	// Connect to the default database (usually "postgres")
-	startTime := time.Now().UnixMilli()
-	db, err := ConnectToPostgresDB(postgresDbUser, postgresDbPassword, postgresDbHost, postgresDbPort, sslMode)
+	db, err := e.pool.get(ctx)
 	if err != nil {
-		utils.ErrorResponse(c, err, startTime, "postgres-connection-open")
-		return
+		return nil, err
 	}
-	defer db.Close()
 
 	// Query to get user activity from pg_stat_statements
 	query := `
@@ -368,36 +681,23 @@ func PostgresGetTotalQueries(c *gin.Context, postgresDbHost, postgresDbUser, pos
 		 pg_roles.rolname,
 		 pg_database.datname
 	`
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
-
-		utils.ErrorResponse(c, err, startTime, "postgres-get-user-activity")
-		return
-
+		return nil, opError("postgres-get-user-activity", err)
 	}
-
 	defer rows.Close()
-	// response that contain all rows
-	var userActivities []struct {
-		Username     string `json:"username"`
-		DatabaseName string `json:"database_name"`
-		TotalQueries int    `json:"total_queries"`
-	}
+
+	var userActivities []QueryActivity
 	for rows.Next() {
-		var userActivity struct {
-			Username     string `json:"username"`
-			DatabaseName string `json:"database_name"`
-			TotalQueries int    `json:"total_queries"`
-		}
+		var userActivity QueryActivity
 		if err := rows.Scan(&userActivity.Username, &userActivity.DatabaseName, &userActivity.TotalQueries); err != nil {
-			utils.ErrorResponse(c, err, startTime, "postgres-scan-user-activity")
-			return
+			return nil, opError("postgres-scan-user-activity", err)
 		}
 		userActivities = append(userActivities, userActivity)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, opError("postgres-get-user-activity", err)
+	}
 
-	utils.SuccessResponse(c, map[string]interface{}{
-		"user_activities": userActivities,
-	}, startTime, "postgres-get-total-queries", "Database Query retrieved successfully")
-
+	return userActivities, nil
 }
@@ -0,0 +1,188 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// postgresGrants are the privileges of an access level on the database,
+// on its public schema and on the tables, sequences and functions in it.
+type postgresGrants struct {
+	database, schema, tables, sequences, functions string
+}
+
+// postgresAccessGrants maps access levels to privileges. The database
+// privileges also tell the levels apart on the server: CREATE for admin,
+// TEMPORARY for read-write and CONNECT alone for read-only.
+var postgresAccessGrants = map[Access]postgresGrants{
+	AccessReadOnly: {
+		database:  "CONNECT",
+		schema:    "USAGE",
+		tables:    "SELECT",
+		sequences: "SELECT",
+	},
+	AccessReadWrite: {
+		database:  "CONNECT, TEMPORARY",
+		schema:    "USAGE",
+		tables:    "SELECT, INSERT, UPDATE, DELETE",
+		sequences: "USAGE, SELECT, UPDATE",
+		functions: "EXECUTE",
+	},
+	AccessAdmin: {
+		database:  "ALL PRIVILEGES",
+		schema:    "USAGE, CREATE",
+		tables:    "ALL PRIVILEGES",
+		sequences: "ALL PRIVILEGES",
+		functions: "ALL PRIVILEGES",
+	},
+}
+
+// objects grants g on what already exists in the public schema.
+func (g postgresGrants) objects(grantee string) []string {
+	to := " TO " + pq.QuoteIdentifier(grantee)
+	statements := []string{
+		"GRANT " + g.schema + " ON SCHEMA public" + to,
+		"GRANT " + g.tables + " ON ALL TABLES IN SCHEMA public" + to,
+		"GRANT " + g.sequences + " ON ALL SEQUENCES IN SCHEMA public" + to,
+	}
+	if g.functions != "" {
+		statements = append(statements, "GRANT "+g.functions+" ON ALL FUNCTIONS IN SCHEMA public"+to)
+	}
+	return statements
+}
+
+// defaults grants g on what owner creates in the public schema from now on.
+func (g postgresGrants) defaults(owner, grantee string) []string {
+	alter := "ALTER DEFAULT PRIVILEGES FOR ROLE " + pq.QuoteIdentifier(owner) + " IN SCHEMA public GRANT "
+	to := " TO " + pq.QuoteIdentifier(grantee)
+	statements := []string{
+		alter + g.tables + " ON TABLES" + to,
+		alter + g.sequences + " ON SEQUENCES" + to,
+	}
+	if g.functions != "" {
+		statements = append(statements, alter+g.functions+" ON FUNCTIONS"+to)
+	}
+	return statements
+}
+
+type postgresUser struct {
+	name   string
+	access Access
+}
+
+// postgresDatabaseAccess lists the login roles granted privileges on
+// dbName, with the access level the grants stand for. The owner of the
+// database and superusers are never returned.
+func postgresDatabaseAccess(ctx context.Context, db *sql.DB, dbName string) ([]postgresUser, error) {
+	query := `
+		SELECT r.rolname,
+			bool_or(a.privilege_type = 'CREATE'),
+			bool_or(a.privilege_type = 'TEMPORARY')
+		FROM pg_database d
+		CROSS JOIN LATERAL aclexplode(d.datacl) a
+		JOIN pg_roles r ON r.oid = a.grantee
+		WHERE d.datname = $1 AND a.grantee <> d.datdba
+			AND NOT r.rolsuper AND r.rolcanlogin
+		GROUP BY r.rolname
+		ORDER BY r.rolname;
+	`
+	rows, err := db.QueryContext(ctx, query, dbName)
+	if err != nil {
+		return nil, opError("postgres-get-existing-users", err)
+	}
+	defer rows.Close()
+
+	var users []postgresUser
+	for rows.Next() {
+		var user postgresUser
+		var create, temporary bool
+		if err := rows.Scan(&user.name, &create, &temporary); err != nil {
+			return nil, opError("postgres-scan-user-name", err)
+		}
+		switch {
+		case create:
+			user.access = AccessAdmin
+		case temporary:
+			user.access = AccessReadWrite
+		default:
+			user.access = AccessReadOnly
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, opError("postgres-get-existing-users", err)
+	}
+	return users, nil
+}
+
+// inDatabase runs statements in one transaction on a connection to dbName,
+// so that they take effect together or not at all. The handle is not kept
+// in a pool: idle connections to dbName would keep it from being renamed
+// or dropped. It is limited to the one connection the statements need, and
+// connecting is bounded like the engine's pool.
+func (e *PostgresEngine) inDatabase(ctx context.Context, dbName string, statements ...string) error {
+	db, err := postgresConnect(e.config, dbName)
+	if err != nil {
+		return codedError("postgres-connection-open", CodeUpstreamUnavailable, err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(e.config.Pool.ConnMaxLifetime)
+
+	pingCtx, cancel := e.config.Pool.pingContext(ctx)
+	defer cancel()
+	if err := db.PingContext(pingCtx); err != nil {
+		return codedError("postgres-connection-open", CodeUpstreamUnavailable, err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("%s: %w", statement, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// postgresHeir is who takes over what a replaced user owned: its
+// replacement username if it is an admin, the manager's own role
+// otherwise, so that no one gains ownership beyond their access level.
+func postgresHeir(username string, access Access) string {
+	if access == AccessAdmin {
+		return pq.QuoteIdentifier(username)
+	}
+	return "CURRENT_USER"
+}
+
+// dropUser drops username and its alternate logins after handing what
+// they own in dbName to heir, a quoted role name or CURRENT_USER. A role
+// cannot be dropped while it owns objects or holds privileges.
+func (e *PostgresEngine) dropUser(ctx context.Context, db *sql.DB, dbName, username, heir string) error {
+	alternates, err := postgresAlternates(ctx, db, username)
+	if err != nil {
+		return err
+	}
+	for _, alternate := range alternates {
+		if err := e.dropUser(ctx, db, dbName, alternate, heir); err != nil {
+			return err
+		}
+	}
+
+	role := pq.QuoteIdentifier(username)
+	if err := e.inDatabase(ctx, dbName, "REASSIGN OWNED BY "+role+" TO "+heir, "DROP OWNED BY "+role); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, "DROP OWNED BY "+role); err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "DROP ROLE "+role)
+	return err
+}
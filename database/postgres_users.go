@@ -0,0 +1,286 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/bonheur15/go-db-manager/utils"
+	"github.com/lib/pq"
+)
+
+// postgresCheckDatabase fails with CodeNotFound when dbName does not exist.
+func postgresCheckDatabase(ctx context.Context, db *sql.DB, dbName string) error {
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", dbName).Scan(&exists); err != nil {
+		return opError("postgres-check-database", err)
+	}
+	if !exists {
+		return codedError("postgres-database-not-exist", CodeNotFound, fmt.Errorf("database %s does not exist", dbName))
+	}
+	return nil
+}
+
+// Users lists the login roles granted privileges on dbName, with the access
+// level their database privileges stand for.
+func (e *PostgresEngine) Users(ctx context.Context, dbName string) ([]DatabaseUser, error) {
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := postgresCheckDatabase(ctx, db, dbName); err != nil {
+		return nil, err
+	}
+	access, err := postgresDatabaseAccess(ctx, db, dbName)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]DatabaseUser, len(access))
+	for i, user := range access {
+		users[i] = DatabaseUser{Username: user.name, Access: user.access}
+	}
+	return users, nil
+}
+
+func (e *PostgresEngine) AddUser(ctx context.Context, opts UserOptions) (*Credentials, error) {
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := postgresCheckDatabase(ctx, db, opts.DatabaseName); err != nil {
+		return nil, err
+	}
+
+	access := opts.Access.Or(DefaultAccess("postgres"))
+	s := newSaga("postgres")
+	username, password, err := e.createUser(ctx, db, opts.DatabaseName, opts.Username, access, s)
+	if err != nil {
+		return nil, err
+	}
+	return &Credentials{Username: username, Password: password, DatabaseName: opts.DatabaseName, Access: access, Steps: s.Steps()}, nil
+}
+
+// RemoveUser drops the user. What it owns in the database goes to another
+// admin of the database, or to the manager's own role when there is none.
+func (e *PostgresEngine) RemoveUser(ctx context.Context, opts UserOptions) error {
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	users, err := postgresCheckUser(ctx, db, opts.DatabaseName, opts.Username)
+	if err != nil {
+		return err
+	}
+
+	heir := "CURRENT_USER"
+	for _, user := range users {
+		if user.name != opts.Username && user.access == AccessAdmin {
+			heir = pq.QuoteIdentifier(user.name)
+			break
+		}
+	}
+	if err := e.dropUser(ctx, db, opts.DatabaseName, opts.Username, heir); err != nil {
+		return opError("postgres-drop-user", err)
+	}
+	return nil
+}
+
+func (e *PostgresEngine) ResetPassword(ctx context.Context, opts UserOptions) (*Credentials, error) {
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := postgresCheckUser(ctx, db, opts.DatabaseName, opts.Username); err != nil {
+		return nil, err
+	}
+	password, err := utils.RandomString(16)
+	if err != nil {
+		return nil, opError("postgres-create-user-random-string", err)
+	}
+	query := fmt.Sprintf("ALTER ROLE %s WITH PASSWORD %s", pq.QuoteIdentifier(opts.Username), pq.QuoteLiteral(password))
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return nil, opError("postgres-reset-password", err)
+	}
+	return &Credentials{Username: opts.Username, Password: password, DatabaseName: opts.DatabaseName}, nil
+}
+
+// postgresCheckUser makes sure username is a user of dbName and of no other
+// database, so that it can be changed or dropped through dbName. It
+// returns the users of dbName.
+func postgresCheckUser(ctx context.Context, db *sql.DB, dbName, username string) ([]postgresUser, error) {
+	if err := postgresCheckDatabase(ctx, db, dbName); err != nil {
+		return nil, err
+	}
+	users, err := postgresDatabaseAccess(ctx, db, dbName)
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, user := range users {
+		found = found || user.name == username
+	}
+	if !found {
+		return nil, codedError("postgres-user-not-exist", CodeNotFound, fmt.Errorf("user %s is not a user of database %s", username, dbName))
+	}
+
+	query := `
+		SELECT DISTINCT d.datname
+		FROM pg_database d
+		CROSS JOIN LATERAL aclexplode(d.datacl) a
+		JOIN pg_roles r ON r.oid = a.grantee
+		WHERE r.rolname = $1 AND d.datname <> $2
+		ORDER BY d.datname;
+	`
+	rows, err := db.QueryContext(ctx, query, username, dbName)
+	if err != nil {
+		return nil, opError("postgres-check-user", err)
+	}
+	defer rows.Close()
+	var others []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, opError("postgres-check-user", err)
+		}
+		others = append(others, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, opError("postgres-check-user", err)
+	}
+	if len(others) > 0 {
+		return nil, codedError("postgres-check-user", CodeConflict, fmt.Errorf("user %s also has access to %s", username, strings.Join(others, ", ")))
+	}
+	return users, nil
+}
+
+// postgresAlternates lists the roles that are members of username. The
+// manager only makes a user a member of another through credential
+// rotation, so these are the alternate logins of username.
+func postgresAlternates(ctx context.Context, db *sql.DB, username string) ([]string, error) {
+	query := `
+		SELECT m.rolname
+		FROM pg_auth_members am
+		JOIN pg_roles r ON r.oid = am.roleid
+		JOIN pg_roles m ON m.oid = am.member
+		WHERE r.rolname = $1
+		ORDER BY m.rolname;
+	`
+	rows, err := db.QueryContext(ctx, query, username)
+	if err != nil {
+		return nil, opError("postgres-get-alternates", err)
+	}
+	defer rows.Close()
+	var alternates []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, opError("postgres-get-alternates", err)
+		}
+		alternates = append(alternates, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, opError("postgres-get-alternates", err)
+	}
+	return alternates, nil
+}
+
+// StartRotation creates an alternate login for the user: a role that is a
+// member of the user and switches to it on login, so that it has the same
+// privileges and what it creates belongs to the user. The old credential
+// is the user's own password, or the previous alternate's.
+func (e *PostgresEngine) StartRotation(ctx context.Context, opts UserOptions) (*Rotation, error) {
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := postgresCheckUser(ctx, db, opts.DatabaseName, opts.Username); err != nil {
+		return nil, err
+	}
+	alternates, err := postgresAlternates(ctx, db, opts.Username)
+	if err != nil {
+		return nil, err
+	}
+	if len(alternates) > 1 {
+		return nil, codedError("postgres-start-rotation", CodeConflict, fmt.Errorf("user %s already has a rotation in progress", opts.Username))
+	}
+	retired := opts.Username
+	if len(alternates) == 1 {
+		retired = alternates[0]
+	}
+
+	alternate, err := rotationUsername(opts.Username)
+	if err != nil {
+		return nil, opError("postgres-create-user-random-string", err)
+	}
+	password, err := utils.RandomString(16)
+	if err != nil {
+		return nil, opError("postgres-create-user-random-string", err)
+	}
+
+	s := newSaga("postgres")
+	err = s.run(ctx, "create-alternate-user", func(ctx context.Context) error {
+		query := fmt.Sprintf("CREATE ROLE %s WITH LOGIN PASSWORD %s IN ROLE %s", pq.QuoteIdentifier(alternate), pq.QuoteLiteral(password), pq.QuoteIdentifier(opts.Username))
+		_, err := db.ExecContext(ctx, query)
+		return err
+	}, func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, fmt.Sprintf("DROP ROLE IF EXISTS %s", pq.QuoteIdentifier(alternate)))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = s.run(ctx, "set-alternate-role", func(ctx context.Context) error {
+		query := fmt.Sprintf("ALTER ROLE %s SET role = %s", pq.QuoteIdentifier(alternate), pq.QuoteIdentifier(opts.Username))
+		_, err := db.ExecContext(ctx, query)
+		return err
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Rotation{
+		DatabaseName:    opts.DatabaseName,
+		Username:        opts.Username,
+		NewUsername:     alternate,
+		Password:        password,
+		RetiredUsername: retired,
+	}, nil
+}
+
+// FinishRotation removes the user's own password when the user is the
+// retired login, and drops the previous alternate otherwise. A user that a
+// reset replaced with another user is dropped, handing what it owns to
+// its replacement if that is an admin.
+func (e *PostgresEngine) FinishRotation(ctx context.Context, rotation Rotation) error {
+	db, err := e.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	if rotation.ReplacesUser {
+		users, err := postgresDatabaseAccess(ctx, db, rotation.DatabaseName)
+		if err != nil {
+			return err
+		}
+		heir := "CURRENT_USER"
+		for _, user := range users {
+			if user.name == rotation.NewUsername {
+				heir = postgresHeir(user.name, user.access)
+			}
+		}
+		err = e.dropUser(ctx, db, rotation.DatabaseName, rotation.RetiredUsername, heir)
+		if err != nil && classify(err) != CodeNotFound {
+			return opError("postgres-finish-rotation", err)
+		}
+		return nil
+	}
+	if rotation.RetiredUsername == rotation.Username {
+		_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER ROLE %s WITH PASSWORD NULL", pq.QuoteIdentifier(rotation.Username)))
+	} else {
+		err = e.dropUser(ctx, db, rotation.DatabaseName, rotation.RetiredUsername, pq.QuoteIdentifier(rotation.Username))
+	}
+	if err != nil && classify(err) != CodeNotFound {
+		return opError("postgres-finish-rotation", err)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package database
+
+import "context"
+
+// ProgressFunc receives progress updates of a long-running operation:
+// done out of total units of work, and what is being worked on.
+type ProgressFunc func(done, total int, message string)
+
+type progressKey struct{}
+
+// WithProgress returns a context whose operations report their progress to
+// fn. Engines report progress for operations that move data, like renames.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+func reportProgress(ctx context.Context, done, total int, message string) {
+	if fn, ok := ctx.Value(progressKey{}).(ProgressFunc); ok {
+		fn(done, total, message)
+	}
+}
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Registry holds the targets the server exposes, grouped by engine. Engines
+// are kept in registration order and each has a default target, used when a
+// reference names only the engine.
+type Registry struct {
+	mu       sync.RWMutex
+	targets  map[string]map[string]Engine
+	configs  map[string]TargetConfig
+	defaults map[string]string
+	order    []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		targets:  make(map[string]map[string]Engine),
+		configs:  make(map[string]TargetConfig),
+		defaults: make(map[string]string),
+	}
+}
+
+// Register adds engine under its target name, keeping config for placement
+// decisions. The first target of an engine is its default until another
+// one is registered with config.Default set.
+func (r *Registry) Register(engine Engine, config TargetConfig) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name, target := engine.Name(), engine.Target()
+	targets, ok := r.targets[name]
+	if !ok {
+		targets = make(map[string]Engine)
+		r.targets[name] = targets
+		r.order = append(r.order, name)
+	}
+	if _, exists := targets[target]; exists {
+		return fmt.Errorf("target %s/%s is already registered", name, target)
+	}
+	targets[target] = engine
+	r.configs[name+"/"+target] = config
+	if config.Default || r.defaults[name] == "" {
+		r.defaults[name] = target
+	}
+	return nil
+}
+
+// Get resolves an "engine" or "engine/target" reference.
+func (r *Registry) Get(ref string) (Engine, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	name, target := ParseRef(ref)
+	targets, ok := r.targets[name]
+	if !ok {
+		return nil, codedError("unknown-engine", CodeNotFound, fmt.Errorf("engine %s is not registered", name))
+	}
+	if target == "" {
+		target = r.defaults[name]
+	}
+	engine, ok := targets[target]
+	if !ok {
+		return nil, codedError("unknown-target", CodeNotFound, fmt.Errorf("target %s/%s is not registered", name, target))
+	}
+	return engine, nil
+}
+
+// EngineNames returns the registered engine names in registration order.
+func (r *Registry) EngineNames() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]string(nil), r.order...)
+}
+
+// Targets returns the targets of engineName sorted by name.
+func (r *Registry) Targets(engineName string) []Engine {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	engines := make([]Engine, 0, len(r.targets[engineName]))
+	for _, engine := range r.targets[engineName] {
+		engines = append(engines, engine)
+	}
+	sort.Slice(engines, func(i, j int) bool { return engines[i].Target() < engines[j].Target() })
+	return engines
+}
+
+// Config returns the configuration engine was registered with.
+func (r *Registry) Config(engine Engine) TargetConfig {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.configs[engine.Name()+"/"+engine.Target()]
+}
+
+func (r *Registry) DefaultTarget(engineName string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.defaults[engineName]
+}
+
+// All returns every registered target of every engine.
+func (r *Registry) All() []Engine {
+	var engines []Engine
+	for _, name := range r.EngineNames() {
+		engines = append(engines, r.Targets(name)...)
+	}
+	return engines
+}
+
+// Connect opens the pool of every registered target. A server that cannot
+// be reached is logged rather than treated as fatal; its pool is opened
+// again on the next request or health check.
+func (r *Registry) Connect(ctx context.Context) {
+	for _, engine := range r.All() {
+		logger := log.With().Str("engine", engine.Name()).Str("target", engine.Target()).Logger()
+		if err := engine.Connect(ctx); err != nil {
+			logger.Error().Err(err).Msg("failed to connect to database server")
+			continue
+		}
+		logger.Info().Msg("connected to database server")
+	}
+}
+
+// Monitor pings every target each interval until ctx is done. A failed ping
+// drops the target's pool so that it is reopened by the next request.
+func (r *Registry) Monitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, engine := range r.All() {
+				if err := engine.Ping(ctx); err != nil {
+					log.Warn().Err(err).Str("engine", engine.Name()).Str("target", engine.Target()).Msg("database server health check failed")
+				}
+			}
+		}
+	}
+}
+
+func (r *Registry) Close() {
+	for _, engine := range r.All() {
+		if err := engine.Close(); err != nil {
+			log.Error().Err(err).Str("engine", engine.Name()).Str("target", engine.Target()).Msg("failed to close connection pool")
+		}
+	}
+}
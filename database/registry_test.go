@@ -0,0 +1,97 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+// stubEngine is an Engine that only knows its name and target; registry
+// tests call nothing else.
+type stubEngine struct {
+	Engine
+	name, target string
+}
+
+func (e *stubEngine) Name() string   { return e.name }
+func (e *stubEngine) Target() string { return e.target }
+
+func TestRegistryGet(t *testing.T) {
+	registry := NewRegistry()
+	primary := &stubEngine{name: "mysql", target: "primary"}
+	replica := &stubEngine{name: "mysql", target: "replica"}
+	for _, engine := range []*stubEngine{primary, replica} {
+		if err := registry.Register(engine, TargetConfig{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		ref  string
+		want Engine
+		code Code
+	}{
+		{"mysql", primary, ""},
+		{"mysql/primary", primary, ""},
+		{"mysql/replica", replica, ""},
+		{"mysql/missing", nil, CodeNotFound},
+		{"postgres", nil, CodeNotFound},
+	}
+	for _, test := range tests {
+		engine, err := registry.Get(test.ref)
+		if test.code != "" {
+			if CodeOf(err) != test.code {
+				t.Errorf("Get(%s) error = %v, want code %s", test.ref, err, test.code)
+			}
+			continue
+		}
+		if err != nil || engine != test.want {
+			t.Errorf("Get(%s) = %v, %v, want %v", test.ref, engine, err, test.want)
+		}
+	}
+}
+
+func TestRegistryDefaultTarget(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register(&stubEngine{name: "postgres", target: "a"}, TargetConfig{})
+	registry.Register(&stubEngine{name: "postgres", target: "b"}, TargetConfig{Default: true})
+	registry.Register(&stubEngine{name: "postgres", target: "c"}, TargetConfig{})
+
+	if got := registry.DefaultTarget("postgres"); got != "b" {
+		t.Errorf("DefaultTarget = %s, want the target registered as default", got)
+	}
+}
+
+func TestRegistryRegisterTwice(t *testing.T) {
+	registry := NewRegistry()
+	if err := registry.Register(&stubEngine{name: "mongo", target: "main"}, TargetConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register(&stubEngine{name: "mongo", target: "main"}, TargetConfig{}); err == nil {
+		t.Error("registering a target twice succeeded")
+	}
+}
+
+func TestRegistryOrder(t *testing.T) {
+	registry := NewRegistry()
+	for _, engine := range []*stubEngine{
+		{name: "postgres", target: "b"},
+		{name: "mysql", target: "main"},
+		{name: "postgres", target: "a"},
+	} {
+		registry.Register(engine, TargetConfig{})
+	}
+
+	if got, want := registry.EngineNames(), []string{"postgres", "mysql"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("EngineNames = %v, want registration order %v", got, want)
+	}
+	var targets []string
+	for _, engine := range registry.Targets("postgres") {
+		targets = append(targets, engine.Target())
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(targets, want) {
+		t.Errorf("Targets = %v, want %v", targets, want)
+	}
+	if got := len(registry.All()); got != 3 {
+		t.Errorf("All returned %d targets, want 3", got)
+	}
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"errors"
+)
+
+type StepStatus string
+
+const (
+	StepDone               StepStatus = "done"
+	StepFailed             StepStatus = "failed"
+	StepCompensated        StepStatus = "compensated"
+	StepCompensationFailed StepStatus = "compensation_failed"
+)
+
+// Step reports one step of a multi-step operation and what became of it.
+type Step struct {
+	Name   string     `json:"name"`
+	Status StepStatus `json:"status"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// StepsError is returned by an operation that failed part-way. Steps lists
+// what ran, including the compensations that undid the completed steps.
+type StepsError struct {
+	Steps []Step
+	Err   error
+}
+
+func (e *StepsError) Error() string { return e.Err.Error() }
+
+func (e *StepsError) Unwrap() error { return e.Err }
+
+// StepsOf returns the steps recorded in err, if any.
+func StepsOf(err error) []Step {
+	var stepsErr *StepsError
+	if errors.As(err, &stepsErr) {
+		return stepsErr.Steps
+	}
+	return nil
+}
+
+// saga runs the steps of an operation in order. When a step fails, the
+// steps that completed are undone in reverse order, so that a failed
+// operation leaves the server as it found it.
+type saga struct {
+	engine string
+	steps  []Step
+	undo   []func(ctx context.Context) error
+}
+
+func newSaga(engine string) *saga {
+	return &saga{engine: engine}
+}
+
+// run executes step name, which must be atomic: a step that fails has
+// changed nothing. undo, if not nil, reverts it should a later step fail.
+// Failures are reported under the action "<engine>-<name>".
+func (s *saga) run(ctx context.Context, name string, do, undo func(ctx context.Context) error) error {
+	return s.exec(ctx, name, do, undo, false)
+}
+
+// runPartial executes step name, which runs several statements and may
+// stop part-way. Its undo runs when the step itself fails as well, so it
+// must only revert what do got done, which do has to keep track of.
+func (s *saga) runPartial(ctx context.Context, name string, do, undo func(ctx context.Context) error) error {
+	return s.exec(ctx, name, do, undo, true)
+}
+
+func (s *saga) exec(ctx context.Context, name string, do, undo func(ctx context.Context) error, partial bool) error {
+	if err := do(ctx); err != nil {
+		s.steps = append(s.steps, Step{Name: name, Status: StepFailed, Error: err.Error()})
+		if partial {
+			s.undo = append(s.undo, undo)
+		} else {
+			s.undo = append(s.undo, nil)
+		}
+		var opErr *OpError
+		if !errors.As(err, &opErr) {
+			err = opError(s.engine+"-"+name, err)
+		}
+		s.compensate(ctx)
+		return &StepsError{Steps: s.steps, Err: err}
+	}
+	s.steps = append(s.steps, Step{Name: name, Status: StepDone})
+	s.undo = append(s.undo, undo)
+	return nil
+}
+
+// compensate undoes the completed steps, and the failed one when it was
+// run with runPartial. It ignores the cancellation of ctx: a client that
+// gave up must not leave the operation half done. The failed step keeps
+// its status and error unless undoing it fails too.
+func (s *saga) compensate(ctx context.Context) {
+	ctx = context.WithoutCancel(ctx)
+	for i := len(s.steps) - 1; i >= 0; i-- {
+		status := s.steps[i].Status
+		if s.undo[i] == nil || (status != StepDone && status != StepFailed) {
+			continue
+		}
+		if err := s.undo[i](ctx); err != nil {
+			s.steps[i].Status = StepCompensationFailed
+			s.steps[i].Error = err.Error()
+			continue
+		}
+		if status == StepDone {
+			s.steps[i].Status = StepCompensated
+		}
+	}
+}
+
+// Steps returns the steps that ran so far.
+func (s *saga) Steps() []Step {
+	return s.steps
+}
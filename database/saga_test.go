@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// recorder returns a step function that appends name to calls and fails
+// with err.
+func recorder(calls *[]string, name string, err error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		*calls = append(*calls, name)
+		return err
+	}
+}
+
+func stepStatuses(steps []Step) []StepStatus {
+	statuses := make([]StepStatus, len(steps))
+	for i, step := range steps {
+		statuses[i] = step.Status
+	}
+	return statuses
+}
+
+func TestSagaRunSucceeds(t *testing.T) {
+	var calls []string
+	s := newSaga("test")
+	for _, name := range []string{"one", "two"} {
+		if err := s.run(context.Background(), name, recorder(&calls, name, nil), recorder(&calls, "undo-"+name, nil)); err != nil {
+			t.Fatalf("run(%s) = %v", name, err)
+		}
+	}
+	if want := []string{"one", "two"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	if got, want := stepStatuses(s.Steps()), []StepStatus{StepDone, StepDone}; !reflect.DeepEqual(got, want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+}
+
+func TestSagaRunCompensatesInReverse(t *testing.T) {
+	var calls []string
+	s := newSaga("test")
+	ctx := context.Background()
+	s.run(ctx, "one", recorder(&calls, "one", nil), recorder(&calls, "undo-one", nil))
+	s.run(ctx, "two", recorder(&calls, "two", nil), nil)
+	s.run(ctx, "three", recorder(&calls, "three", nil), recorder(&calls, "undo-three", nil))
+	err := s.run(ctx, "four", recorder(&calls, "four", errors.New("boom")), recorder(&calls, "undo-four", nil))
+
+	// The failed step is atomic, so only the completed steps are undone.
+	want := []string{"one", "two", "three", "four", "undo-three", "undo-one"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	wantStatuses := []StepStatus{StepCompensated, StepDone, StepCompensated, StepFailed}
+	if got := stepStatuses(StepsOf(err)); !reflect.DeepEqual(got, wantStatuses) {
+		t.Errorf("statuses = %v, want %v", got, wantStatuses)
+	}
+	if got := ActionOf(err, ""); got != "test-four" {
+		t.Errorf("action = %q, want test-four", got)
+	}
+}
+
+func TestSagaRunPartialUndoesFailedStep(t *testing.T) {
+	var calls []string
+	s := newSaga("test")
+	ctx := context.Background()
+	s.run(ctx, "one", recorder(&calls, "one", nil), recorder(&calls, "undo-one", nil))
+	err := s.runPartial(ctx, "two", recorder(&calls, "two", errors.New("boom")), recorder(&calls, "undo-two", nil))
+
+	want := []string{"one", "two", "undo-two", "undo-one"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	// The failed step keeps its status and error once undone.
+	steps := StepsOf(err)
+	if got, want := stepStatuses(steps), []StepStatus{StepCompensated, StepFailed}; !reflect.DeepEqual(got, want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+	if steps[1].Error != "boom" {
+		t.Errorf("failed step error = %q, want boom", steps[1].Error)
+	}
+}
+
+func TestSagaCompensationFailure(t *testing.T) {
+	var calls []string
+	s := newSaga("test")
+	ctx := context.Background()
+	s.run(ctx, "one", recorder(&calls, "one", nil), recorder(&calls, "undo-one", nil))
+	s.run(ctx, "two", recorder(&calls, "two", nil), recorder(&calls, "undo-two", errors.New("stuck")))
+	err := s.run(ctx, "three", recorder(&calls, "three", errors.New("boom")), nil)
+
+	// A failed undo does not stop the earlier steps from being undone.
+	want := []string{"one", "two", "three", "undo-two", "undo-one"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	steps := StepsOf(err)
+	if got, want := stepStatuses(steps), []StepStatus{StepCompensated, StepCompensationFailed, StepFailed}; !reflect.DeepEqual(got, want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+	if steps[1].Error != "stuck" {
+		t.Errorf("compensation error = %q, want stuck", steps[1].Error)
+	}
+}
+
+func TestSagaCompensatesAfterCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newSaga("test")
+	undone := false
+	s.run(ctx, "one", func(ctx context.Context) error { return nil }, func(ctx context.Context) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		undone = true
+		return nil
+	})
+	cancel()
+	s.run(ctx, "two", func(ctx context.Context) error { return ctx.Err() }, nil)
+	if !undone {
+		t.Error("the completed step was not undone after the context was canceled")
+	}
+}
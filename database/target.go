@@ -0,0 +1,140 @@
+package database
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultTargetName is used for the single server configured through the
+// legacy environment variables.
+const DefaultTargetName = "default"
+
+// reservedTargetNames cannot be used as target names because they collide
+// with the routes mounted next to /{engine}/:target.
+var reservedTargetNames = map[string]bool{
+	"databases": true,
+	"ping":      true,
+	"queries":   true,
+	"targets":   true,
+}
+
+// TargetConfig describes one database server in the inventory. MySQL and
+// Postgres use Host/Port/User/Password; MongoDB uses URI.
+type TargetConfig struct {
+	Engine   string    `json:"engine"`
+	Name     string    `json:"name"`
+	Default  bool      `json:"default"`
+	Host     string    `json:"host"`
+	Port     string    `json:"port"`
+	User     string    `json:"user"`
+	Password string    `json:"password"`
+	URI      string    `json:"uri"`
+	TLS      TLSConfig `json:"tls"`
+	// Weight, CapacityBytes and Local feed the placement policies: Weight
+	// for weighted round-robin (default 1), CapacityBytes to compute free
+	// disk, and Local marks a server running on this host, whose free disk
+	// without CapacityBytes is that of the filesystem at DataPath.
+	Weight        int    `json:"weight"`
+	CapacityBytes int64  `json:"capacity_bytes"`
+	Local         bool   `json:"local"`
+	DataPath      string `json:"data_path"`
+	// CreateDefaults are the creation options of new databases on the
+	// target that the create request leaves unset.
+	CreateDefaults CreationOptions `json:"create_defaults"`
+	// Pool is filled in by the config loader; it has no JSON form because
+	// durations are written as strings in the inventory file.
+	Pool PoolConfig `json:"-"`
+}
+
+// Ref returns the "engine/target" reference used to address the target.
+func (t TargetConfig) Ref() string {
+	return t.Engine + "/" + t.Name
+}
+
+func (t TargetConfig) validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("%s target has no name", t.Engine)
+	}
+	if reservedTargetNames[t.Name] || strings.Contains(t.Name, "/") {
+		return fmt.Errorf("%s is not a valid target name", t.Name)
+	}
+	if err := ValidateFor(t.Engine, t.CreateDefaults); err != nil {
+		return fmt.Errorf("target %s create_defaults: %w", t.Ref(), err)
+	}
+	return nil
+}
+
+// TLSConfig holds the TLS settings of a target. Mode follows the Postgres
+// sslmode values (disable, require, verify-ca, verify-full); for MySQL and
+// MongoDB any mode other than disable turns TLS on, and require skips
+// certificate verification.
+type TLSConfig struct {
+	Mode       string `json:"mode"`
+	CAFile     string `json:"ca_file"`
+	CertFile   string `json:"cert_file"`
+	KeyFile    string `json:"key_file"`
+	ServerName string `json:"server_name"`
+}
+
+func (c TLSConfig) enabled() bool {
+	return c.Mode != "" && c.Mode != "disable"
+}
+
+// clientConfig builds a *tls.Config for drivers that take one directly. It
+// returns nil when TLS is disabled.
+func (c TLSConfig) clientConfig() (*tls.Config, error) {
+	if !c.enabled() {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.Mode == "require",
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading TLS CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading TLS client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// ParseRef splits an "engine/target" reference. The target is empty when
+// ref names only the engine, which selects the engine's default target.
+func ParseRef(ref string) (engine, target string) {
+	engine, target, _ = strings.Cut(ref, "/")
+	return engine, target
+}
+
+// NewEngine builds the engine implementation for target.Engine.
+func NewEngine(target TargetConfig) (Engine, error) {
+	if err := target.validate(); err != nil {
+		return nil, err
+	}
+	switch target.Engine {
+	case "mysql":
+		return NewMySQLEngine(target)
+	case "postgres":
+		return NewPostgresEngine(target)
+	case "mongo":
+		return NewMongoEngine(target)
+	default:
+		return nil, fmt.Errorf("unsupported engine %q for target %s", target.Engine, target.Name)
+	}
+}
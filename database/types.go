@@ -0,0 +1,349 @@
+package database
+
+import "time"
+
+type CreateOptions struct {
+	DatabaseName string `json:"database_name" validate:"required,dbname"`
+	// Placement overrides the manager's placement policy when no target is
+	// given. Engines ignore it.
+	Placement string `json:"placement" validate:"omitempty,oneof=fewest-databases most-free-disk lowest-load weighted-round-robin"`
+	// Owner and Labels are recorded in the catalog. Engines ignore them.
+	Owner  string            `json:"owner"`
+	Labels map[string]string `json:"labels"`
+	// DeletionProtection is recorded in the catalog, where it blocks
+	// deletes and renames. Engines ignore it.
+	DeletionProtection bool `json:"deletion_protection"`
+	// Access is what the generated user may do, by default the engine's
+	// DefaultAccess.
+	Access Access `json:"access" validate:"omitempty,oneof=read-only read-write admin"`
+	// Options are passed to CREATE DATABASE. Unset options fall back to
+	// the target's defaults, then to the server's.
+	Options CreationOptions `json:"options"`
+}
+
+// Access is the level of access a generated user has to its database.
+type Access string
+
+const (
+	// AccessReadOnly reads data: SELECT on MySQL and Postgres, read on
+	// MongoDB.
+	AccessReadOnly Access = "read-only"
+	// AccessReadWrite reads and changes data but not the schema.
+	AccessReadWrite Access = "read-write"
+	// AccessAdmin also changes the schema.
+	AccessAdmin Access = "admin"
+)
+
+// DefaultAccess is the access of users created without one, which is what
+// generated users have always had: every privilege on MySQL and Postgres,
+// readWrite on MongoDB.
+func DefaultAccess(engine string) Access {
+	if engine == "mongo" {
+		return AccessReadWrite
+	}
+	return AccessAdmin
+}
+
+// Or returns a, or fallback when a is unset.
+func (a Access) Or(fallback Access) Access {
+	if a == "" {
+		return fallback
+	}
+	return a
+}
+
+// CreationOptions are the engine-specific settings of a new database. Each
+// is only accepted by the engine it applies to.
+type CreationOptions struct {
+	// Charset and Collation are the MySQL CHARACTER SET and COLLATE.
+	Charset   string `json:"charset" validate:"omitempty,engine=mysql,charset"`
+	Collation string `json:"collation" validate:"omitempty,engine=mysql,charset"`
+	// Encoding, LCCollate, LCCtype, Template, Tablespace and
+	// ConnectionLimit are the Postgres options of the same names. A
+	// ConnectionLimit of -1 means no limit.
+	Encoding        string `json:"encoding" validate:"omitempty,engine=postgres,locale"`
+	LCCollate       string `json:"lc_collate" validate:"omitempty,engine=postgres,locale"`
+	LCCtype         string `json:"lc_ctype" validate:"omitempty,engine=postgres,locale"`
+	Template        string `json:"template" validate:"omitempty,engine=postgres,postgres_dbname"`
+	Tablespace      string `json:"tablespace" validate:"omitempty,engine=postgres,postgres_dbname"`
+	ConnectionLimit *int   `json:"connection_limit" validate:"omitempty,engine=postgres,min=-1"`
+	// Collections are created in a new MongoDB database before its user.
+	Collections []MongoCollection `json:"collections" validate:"omitempty,engine=mongo,dive"`
+}
+
+// MongoCollection describes a collection to create with a new database.
+// Capped and TimeSeries exclude each other.
+type MongoCollection struct {
+	Name string `json:"name" validate:"required"`
+	// JSONSchema becomes the collection's $jsonSchema validator.
+	JSONSchema       map[string]interface{} `json:"json_schema"`
+	ValidationLevel  string                 `json:"validation_level" validate:"omitempty,oneof=off strict moderate"`
+	ValidationAction string                 `json:"validation_action" validate:"omitempty,oneof=error warn"`
+	Capped           *MongoCapped           `json:"capped"`
+	TimeSeries       *MongoTimeSeries       `json:"time_series"`
+	Indexes          []MongoIndex           `json:"indexes" validate:"dive"`
+}
+
+// MongoCapped limits a capped collection's size in bytes and, optionally,
+// its number of documents.
+type MongoCapped struct {
+	SizeBytes    int64 `json:"size_bytes" validate:"required,min=1"`
+	MaxDocuments int64 `json:"max_documents" validate:"min=0"`
+}
+
+// MongoTimeSeries makes a time-series collection, which needs MongoDB 5.0.
+type MongoTimeSeries struct {
+	TimeField          string `json:"time_field" validate:"required"`
+	MetaField          string `json:"meta_field"`
+	Granularity        string `json:"granularity" validate:"omitempty,oneof=seconds minutes hours"`
+	ExpireAfterSeconds *int64 `json:"expire_after_seconds" validate:"omitempty,min=0"`
+}
+
+// MongoIndex describes an index of an initial collection. The keys are a
+// list because their order matters.
+type MongoIndex struct {
+	Name               string          `json:"name"`
+	Keys               []MongoIndexKey `json:"keys" validate:"required,min=1,dive"`
+	Unique             bool            `json:"unique"`
+	Sparse             bool            `json:"sparse"`
+	ExpireAfterSeconds *int32          `json:"expire_after_seconds" validate:"omitempty,min=0"`
+}
+
+// MongoIndexKey is one field of an index. Order defaults to asc.
+type MongoIndexKey struct {
+	Field string `json:"field" validate:"required"`
+	Order string `json:"order" validate:"omitempty,oneof=asc desc text 2dsphere hashed"`
+}
+
+// withDefaults fills the options o leaves unset from defaults. A MySQL
+// character set and collation only make sense together, so the default
+// pair is only used when o sets neither.
+func (o CreationOptions) withDefaults(defaults CreationOptions) CreationOptions {
+	if o.Charset == "" && o.Collation == "" {
+		o.Charset, o.Collation = defaults.Charset, defaults.Collation
+	}
+	if o.Encoding == "" {
+		o.Encoding = defaults.Encoding
+	}
+	if o.LCCollate == "" {
+		o.LCCollate = defaults.LCCollate
+	}
+	if o.LCCtype == "" {
+		o.LCCtype = defaults.LCCtype
+	}
+	if o.Template == "" {
+		o.Template = defaults.Template
+	}
+	if o.Tablespace == "" {
+		o.Tablespace = defaults.Tablespace
+	}
+	if o.ConnectionLimit == nil {
+		o.ConnectionLimit = defaults.ConnectionLimit
+	}
+	if o.Collections == nil {
+		o.Collections = defaults.Collections
+	}
+	return o
+}
+
+// DatabaseOptions identifies an existing database.
+type DatabaseOptions struct {
+	DatabaseName string `json:"database_name" validate:"required,dbname"`
+}
+
+type DeleteOptions struct {
+	DatabaseName string `json:"database_name" validate:"required,dbname"`
+	// Users are the users known to belong to the database, from the
+	// catalog. They are dropped along with it. MongoDB drops every user of
+	// the database instead.
+	Users []string `json:"-"`
+	// Permanent drops the database right away instead of moving it to the
+	// manager's recycle bin. Engines ignore it.
+	Permanent bool `json:"permanent"`
+}
+
+type RenameOptions struct {
+	OldDatabaseName string `json:"old_database_name" validate:"required,dbname"`
+	NewDatabaseName string `json:"new_database_name" validate:"required,dbname"`
+	// Force terminates the sessions connected to the database instead of
+	// waiting up to WaitSeconds (default: DefaultRenameWait) for them to
+	// end. Only engines that cannot rename a database in use look at them.
+	Force       bool `json:"force"`
+	WaitSeconds int  `json:"wait_seconds" validate:"min=0,max=3600"`
+}
+
+// DefaultRenameWait is how long a rename waits for sessions on the
+// database to end when RenameOptions.WaitSeconds is not set.
+const DefaultRenameWait = 30 * time.Second
+
+// Wait returns how long the rename may wait for sessions to end.
+func (o RenameOptions) Wait() time.Duration {
+	if o.WaitSeconds == 0 {
+		return DefaultRenameWait
+	}
+	return time.Duration(o.WaitSeconds) * time.Second
+}
+
+type ResetCredentialsOptions struct {
+	DatabaseName string `json:"database_name" validate:"required,dbname"`
+	// Username selects the user to reset. Engines that track their own
+	// users (MySQL, Postgres) ignore it; MongoDB requires it unless the
+	// catalog knows exactly one user of the database.
+	Username string `json:"username" validate:"omitempty,username"`
+	// Access is the access of the replacement user on MySQL and Postgres,
+	// by default the engine's DefaultAccess. MongoDB changes the user's
+	// roles only when it is set.
+	Access Access `json:"access" validate:"omitempty,oneof=read-only read-write admin"`
+	// Users are the users known to belong to the database, from the
+	// catalog. When set, MySQL and Postgres replace exactly these users
+	// instead of looking them up on the server.
+	Users []string `json:"-"`
+	// GraceSeconds keeps the old credentials working for that long after
+	// the reset, as a credential rotation tracked by the manager. Without
+	// it, or when it is zero, they stop working right away. Engines ignore
+	// it.
+	GraceSeconds *int `json:"grace_seconds" validate:"omitempty,min=0"`
+	// KeepUsers asks the engine to create the replacement as a user of its
+	// own and leave the old users in place, for the manager to retire
+	// later. MongoDB then creates a new user instead of changing the
+	// password.
+	KeepUsers bool `json:"-"`
+}
+
+// UserOptions identifies a user of a database, or describes one to add.
+type UserOptions struct {
+	DatabaseName string `json:"database_name" validate:"required,dbname"`
+	// Username names the user. A user added without one gets a random
+	// name.
+	Username string `json:"username" validate:"omitempty,username"`
+	// Access is the access of an added user, by default the engine's
+	// DefaultAccess.
+	Access Access `json:"access" validate:"omitempty,oneof=read-only read-write admin"`
+}
+
+// Rotation is a second credential for a user, handed out while the first
+// keeps working. Depending on the engine it is a second password of the
+// same user or another login acting as the user.
+type Rotation struct {
+	DatabaseName string `json:"database_name"`
+	Username     string `json:"username"`
+	// NewUsername and Password are the new credential.
+	NewUsername string `json:"new_username"`
+	Password    string `json:"password,omitempty"`
+	// RetiredUsername is the login whose credential stops working when
+	// the rotation is finished.
+	RetiredUsername string `json:"retired_username"`
+	// ReplacesUser means NewUsername is a user of its own that takes the
+	// place of RetiredUsername, which is dropped when the rotation is
+	// finished.
+	ReplacesUser bool `json:"replaces_user"`
+	// RetireAt is when the manager finishes the rotation, if it is not
+	// confirmed earlier.
+	RetireAt *time.Time `json:"retire_at,omitempty"`
+}
+
+// DatabaseUser is a user with access to a database. Access is empty when
+// the user's privileges do not match an access level.
+type DatabaseUser struct {
+	Username string `json:"username"`
+	Access   Access `json:"access,omitempty"`
+}
+
+// Credentials are returned once, when a user is created or its password is
+// reset. The password is never stored by the manager.
+type Credentials struct {
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	DatabaseName string `json:"database_name,omitempty"`
+	Access       Access `json:"access,omitempty"`
+	// Target is the server the database lives on, set by the manager.
+	Target string `json:"target,omitempty"`
+	// Steps lists the steps a create ran.
+	Steps []Step `json:"steps,omitempty"`
+	// Replaced are the users a reset with KeepUsers left in place.
+	Replaced []string `json:"-"`
+	// RetireAt is when the users replaced by a reset stop working, set by
+	// the manager.
+	RetireAt *time.Time `json:"retire_at,omitempty"`
+}
+
+type RenameResult struct {
+	OldDatabaseName string `json:"old_database_name"`
+	NewDatabaseName string `json:"new_database_name"`
+	// Users are the users that had to be recreated for the new name, with
+	// their new passwords.
+	Users []Credentials `json:"users,omitempty"`
+	// PasswordsReset means the old passwords of Users no longer work.
+	PasswordsReset bool            `json:"passwords_reset,omitempty"`
+	Moved          *RenamedObjects `json:"moved,omitempty"`
+	Steps          []Step          `json:"steps,omitempty"`
+}
+
+// RenamedObjects lists what a rename moved to the new database name.
+type RenamedObjects struct {
+	Tables     []string `json:"tables,omitempty"`
+	Views      []string `json:"views,omitempty"`
+	Procedures []string `json:"procedures,omitempty"`
+	Functions  []string `json:"functions,omitempty"`
+	Triggers   []string `json:"triggers,omitempty"`
+	Events     []string `json:"events,omitempty"`
+	Grants     []string `json:"grants,omitempty"`
+}
+
+type TableStat struct {
+	Table  string  `json:"table"`
+	SizeMB float64 `json:"size_mb"`
+}
+
+// DatabaseStats holds per-table sizes for SQL engines and the raw collStats
+// output for MongoDB collections.
+type DatabaseStats struct {
+	DatabaseName string                   `json:"database_name"`
+	Tables       []TableStat              `json:"tables,omitempty"`
+	Collections  []map[string]interface{} `json:"collections,omitempty"`
+}
+
+type QueryActivity struct {
+	Username     string `json:"username"`
+	DatabaseName string `json:"database_name"`
+	TotalQueries int    `json:"total_queries"`
+}
+
+// DatabaseInfo is what a server reports about one database. Fields an
+// engine cannot report are left empty: MySQL and Postgres do not record
+// creation times, and MongoDB has no per-database encoding.
+type DatabaseInfo struct {
+	DatabaseName string     `json:"database_name"`
+	SizeBytes    int64      `json:"size_bytes"`
+	Encoding     string     `json:"encoding,omitempty"`
+	Collation    string     `json:"collation,omitempty"`
+	CreatedAt    *time.Time `json:"created_at,omitempty"`
+	Connections  int        `json:"connections"`
+	// Users are the users with access to the database, as found on the
+	// server.
+	Users []string `json:"users"`
+}
+
+// ServerUsage is what a target reports for placement decisions.
+type ServerUsage struct {
+	DatabaseCount  int   `json:"database_count"`
+	UsedBytes      int64 `json:"used_bytes"`
+	Connections    int   `json:"connections"`
+	MaxConnections int   `json:"max_connections"`
+}
+
+// DatabaseNames returns the databases an operation touches, so that the
+// manager can check them against its name policy.
+func (o CreateOptions) DatabaseNames() []string { return []string{o.DatabaseName} }
+
+func (o UserOptions) DatabaseNames() []string { return []string{o.DatabaseName} }
+
+func (o DatabaseOptions) DatabaseNames() []string { return []string{o.DatabaseName} }
+
+func (o DeleteOptions) DatabaseNames() []string { return []string{o.DatabaseName} }
+
+func (o RenameOptions) DatabaseNames() []string {
+	return []string{o.OldDatabaseName, o.NewDatabaseName}
+}
+
+func (o ResetCredentialsOptions) DatabaseNames() []string { return []string{o.DatabaseName} }
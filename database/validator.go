@@ -1,6 +1,14 @@
 package database
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/bonheur15/go-db-manager/utils"
 	"github.com/go-playground/validator/v10"
 )
 
@@ -8,4 +16,205 @@ var validate *validator.Validate
 
 func init() {
 	validate = validator.New()
+	// Report fields under the names clients send them as.
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			return field.Name
+		}
+		return name
+	})
+
+	for engine := range nameRules {
+		validate.RegisterValidation(engine+"_dbname", func(fl validator.FieldLevel) bool {
+			return NameProblem(engine, fl.Field().String()) == ""
+		})
+	}
+	// engine restricts a field to one engine's requests. Structs validated
+	// without an engine accept every field.
+	validate.RegisterValidationCtx("engine", func(ctx context.Context, fl validator.FieldLevel) bool {
+		engine, _ := ctx.Value(validationEngineKey{}).(string)
+		return engine == "" || engine == fl.Param()
+	})
+	validate.RegisterValidation("charset", func(fl validator.FieldLevel) bool {
+		return charsetPattern.MatchString(fl.Field().String())
+	})
+	validate.RegisterValidation("locale", func(fl validator.FieldLevel) bool {
+		return localePattern.MatchString(fl.Field().String())
+	})
+	validate.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return usernamePattern.MatchString(fl.Field().String())
+	})
+	// dbname applies the rules of the engine the struct is validated for,
+	// or only the common ones when there is none.
+	validate.RegisterValidationCtx("dbname", func(ctx context.Context, fl validator.FieldLevel) bool {
+		engine, _ := ctx.Value(validationEngineKey{}).(string)
+		return NameProblem(engine, fl.Field().String()) == ""
+	})
+}
+
+type validationEngineKey struct{}
+
+// databaseNamePattern is what every engine accepts: letters, digits,
+// underscores and hyphens, not starting with a hyphen.
+var databaseNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_-]*$`)
+
+// usernamePattern matches the names of added users, which must fit every
+// engine: MySQL allows at most 32 characters.
+var usernamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,31}$`)
+
+// rotationUsername names the login added by a rotation of username,
+// shortening username so that the result still matches usernamePattern.
+func rotationUsername(username string) (string, error) {
+	suffix, err := utils.RandomString(8)
+	if err != nil {
+		return "", err
+	}
+	return username[:min(len(username), 23)] + "_" + strings.ToLower(suffix), nil
+}
+
+// charsetPattern matches MySQL character set and collation names, and
+// localePattern Postgres encoding and locale names such as en_US.UTF-8.
+// Both are written into CREATE DATABASE, so nothing else is let through.
+var (
+	charsetPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+	localePattern  = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+)
+
+// nameRules holds the engine-specific database name rules. Each returns
+// why name is not acceptable, or "".
+var nameRules = map[string]func(name string) string{
+	"mysql": func(name string) string {
+		if len([]rune(name)) > 64 {
+			return "must be at most 64 characters on mysql"
+		}
+		return ""
+	},
+	"postgres": func(name string) string {
+		if len(name) > 63 {
+			return "must be at most 63 bytes on postgres"
+		}
+		return ""
+	},
+	"mongo": func(name string) string {
+		if i := strings.IndexAny(name, "/\\. \"$*<>:|?\x00"); i >= 0 {
+			return fmt.Sprintf("must not contain %q on mongo", name[i])
+		}
+		if len(name) >= 64 {
+			return "must be shorter than 64 bytes on mongo"
+		}
+		return ""
+	},
+}
+
+// NameProblem explains why name cannot be used as a database name on
+// engine, or returns "" when it can. An empty engine only applies the
+// rules every engine shares.
+func NameProblem(engine, name string) string {
+	if !databaseNamePattern.MatchString(name) {
+		return "must contain only letters, digits, underscores and hyphens, and not start with a hyphen"
+	}
+	if rule, ok := nameRules[engine]; ok {
+		return rule(name)
+	}
+	return ""
+}
+
+// FieldError describes one field that failed validation.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every field of a request that failed validation.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Field + " " + field.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// FieldsOf returns the field errors recorded in err, if any.
+func FieldsOf(err error) []FieldError {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Fields
+	}
+	return nil
+}
+
+// ValidateStruct checks a request body against its validate tags.
+func ValidateStruct(s interface{}) error {
+	return ValidateFor("", s)
+}
+
+// ValidateFor checks a request body against its validate tags, applying
+// the database name rules of engine. Failures are a *ValidationError.
+func ValidateFor(engine string, s interface{}) error {
+	ctx := context.WithValue(context.Background(), validationEngineKey{}, engine)
+	err := validate.StructCtx(ctx, s)
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return err
+	}
+	fields := make([]FieldError, len(validationErrs))
+	for i, fieldErr := range validationErrs {
+		fields[i] = FieldError{
+			Field:   fieldPath(fieldErr),
+			Rule:    fieldErr.Tag(),
+			Message: fieldMessage(engine, fieldErr),
+		}
+	}
+	return &ValidationError{Fields: fields}
+}
+
+// ValidateName checks a database name that did not come from a request
+// field, such as one built from a naming template, reporting it as field.
+func ValidateName(engine, field, name string) error {
+	if problem := NameProblem(engine, name); problem != "" {
+		return &ValidationError{Fields: []FieldError{{Field: field, Rule: "dbname", Message: problem}}}
+	}
+	return nil
+}
+
+// fieldPath drops the struct name from the namespace of a field error.
+func fieldPath(fieldErr validator.FieldError) string {
+	_, path, ok := strings.Cut(fieldErr.Namespace(), ".")
+	if !ok {
+		return fieldErr.Field()
+	}
+	return path
+}
+
+func fieldMessage(engine string, fieldErr validator.FieldError) string {
+	switch tag := fieldErr.Tag(); tag {
+	case "required":
+		return "is required"
+	case "dbname":
+		return NameProblem(engine, fmt.Sprint(fieldErr.Value()))
+	case "mysql_dbname", "postgres_dbname", "mongo_dbname":
+		return NameProblem(strings.TrimSuffix(tag, "_dbname"), fmt.Sprint(fieldErr.Value()))
+	case "engine":
+		return "is only supported on " + fieldErr.Param()
+	case "username":
+		return "must start with a letter and contain at most 32 letters, digits and underscores"
+	case "charset":
+		return "must contain only letters, digits and underscores"
+	case "locale":
+		return "must contain only letters, digits, underscores, hyphens, dots and @"
+	case "min":
+		return "must be at least " + fieldErr.Param()
+	case "max":
+		return "must be at most " + fieldErr.Param()
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(fieldErr.Param()), ", ")
+	default:
+		return "fails the " + tag + " rule"
+	}
 }
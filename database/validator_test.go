@@ -0,0 +1,42 @@
+package database
+
+import "testing"
+
+func TestRotationUsernameIsValid(t *testing.T) {
+	for _, username := range []string{"app", "app_user", "a234567890123456789012345678901x"} {
+		rotated, err := rotationUsername(username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !usernamePattern.MatchString(rotated) {
+			t.Errorf("rotationUsername(%s) = %s, which is not a valid username", username, rotated)
+		}
+		// The rotated login has to be usable in later requests.
+		if err := ValidateFor("mysql", ResetCredentialsOptions{DatabaseName: "shop", Username: rotated}); err != nil {
+			t.Errorf("resetting %s = %v", rotated, err)
+		}
+		if err := ValidateFor("mysql", UserOptions{DatabaseName: "shop", Username: rotated}); err != nil {
+			t.Errorf("rotating %s = %v", rotated, err)
+		}
+	}
+}
+
+func TestResetCredentialsUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		valid    bool
+	}{
+		{"", true},
+		{"alice", true},
+		{"app_user_2", true},
+		{"2fast", false},
+		{"app-user", false},
+		{"robert'); DROP TABLE students;--", false},
+	}
+	for _, test := range tests {
+		err := ValidateFor("postgres", ResetCredentialsOptions{DatabaseName: "shop", Username: test.username})
+		if (err == nil) != test.valid {
+			t.Errorf("username %q: error = %v, want valid %v", test.username, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/bonheur15/go-db-manager/database"
+	"github.com/gin-gonic/gin"
+)
+
+// ConfirmationHeader carries the token that confirms a destructive request.
+const ConfirmationHeader = "Confirmation-Token"
+
+// Confirmations issues and checks the tokens of the two-step flow for
+// destructive requests: the first request is refused with a token, and
+// only the same request repeated with the token runs.
+//
+// Tokens are signed rather than stored, so replicas sharing the secret
+// accept each other's tokens. A token is bound to the method, path, query
+// string and body of the request it was issued for, so that a token for a
+// delete does not also confirm it with ?permanent=true.
+type Confirmations struct {
+	secret  []byte
+	ttl     time.Duration
+	engines map[string]bool
+}
+
+// NewConfirmations requires confirmation for the destructive requests of
+// engines, or of every engine when engines contains "*". Tokens expire
+// after ttl.
+func NewConfirmations(secret []byte, ttl time.Duration, engines []string) *Confirmations {
+	c := &Confirmations{secret: secret, ttl: ttl, engines: make(map[string]bool)}
+	for _, engine := range engines {
+		c.engines[engine] = true
+	}
+	return c
+}
+
+// Required reports whether destructive requests to engineName need a
+// confirmation token.
+func (c *Confirmations) Required(engineName string) bool {
+	return c != nil && (c.engines["*"] || c.engines[engineName])
+}
+
+// Confirm makes the requests it guards on engineName's routes two-step
+// when confirmations are required for the engine. Without a valid token
+// the request is answered with 428 confirmation_required and a token.
+func Confirm(confirmations *Confirmations, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !confirmations.Required(engineName) {
+			c.Next()
+			return
+		}
+		startTime := time.Now().UnixMilli()
+		action := engineName + "-confirm"
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			respondError(c, invalidArgument(engineName+"-read-body", err), startTime, engineName+"-read-body")
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		fp := fingerprint(c.Request, body)
+
+		token := c.GetHeader(ConfirmationHeader)
+		if token != "" && confirmations.valid(token, fp, time.Now()) {
+			c.Next()
+			return
+		}
+
+		message := "this request must be confirmed: repeat it with the " + ConfirmationHeader + " header"
+		if token != "" {
+			message = "the confirmation token is invalid or has expired: repeat the request with the new token"
+		}
+		expiresAt := time.Now().Add(confirmations.ttl).UTC()
+		writeError(c, httpStatus(database.CodeConfirmationRequired, nil), database.CodeConfirmationRequired, errors.New(message), map[string]interface{}{
+			"confirmation_token": confirmations.issue(fp, expiresAt),
+			"expires_at":         expiresAt,
+		}, startTime, action)
+		c.Abort()
+	}
+}
+
+// issue returns a token for the request with fingerprint fp, valid until
+// expiresAt: the expiry followed by a MAC over both.
+func (c *Confirmations) issue(fp string, expiresAt time.Time) string {
+	token := binary.BigEndian.AppendUint64(nil, uint64(expiresAt.Unix()))
+	token = append(token, c.mac(fp, token)...)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+func (c *Confirmations) valid(token, fp string, now time.Time) bool {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) != 8+sha256.Size {
+		return false
+	}
+	expiry, mac := data[:8], data[8:]
+	if !hmac.Equal(mac, c.mac(fp, expiry)) {
+		return false
+	}
+	return now.Unix() <= int64(binary.BigEndian.Uint64(expiry))
+}
+
+func (c *Confirmations) mac(fp string, expiry []byte) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write(expiry)
+	mac.Write([]byte(fp))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConfirmationToken(t *testing.T) {
+	confirmations := NewConfirmations([]byte("secret"), time.Minute, []string{"*"})
+	now := time.Now()
+	fp := fingerprint(httptest.NewRequest("DELETE", "/v1/mysql/databases/shop", nil), nil)
+	token := confirmations.issue(fp, now.Add(time.Minute))
+
+	if !confirmations.valid(token, fp, now) {
+		t.Error("token is not valid for the request it was issued for")
+	}
+	if confirmations.valid(token, fp, now.Add(2*time.Minute)) {
+		t.Error("token is valid after it expired")
+	}
+	permanent := fingerprint(httptest.NewRequest("DELETE", "/v1/mysql/databases/shop?permanent=true", nil), nil)
+	if confirmations.valid(token, permanent, now) {
+		t.Error("token for a delete also confirms it with ?permanent=true")
+	}
+	other := NewConfirmations([]byte("other secret"), time.Minute, []string{"*"})
+	if other.valid(token, fp, now) {
+		t.Error("token is valid under another secret")
+	}
+	if confirmations.valid("not a token", fp, now) {
+		t.Error("a malformed token is valid")
+	}
+}
+
+func TestConfirmationsRequired(t *testing.T) {
+	var none *Confirmations
+	if none.Required("mysql") {
+		t.Error("nil confirmations require confirmation")
+	}
+	some := NewConfirmations(nil, time.Minute, []string{"postgres"})
+	if some.Required("mysql") || !some.Required("postgres") {
+		t.Error("confirmations are not limited to the listed engines")
+	}
+}
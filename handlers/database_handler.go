@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bonheur15/go-db-manager/database"
+	"github.com/bonheur15/go-db-manager/manager"
+	"github.com/bonheur15/go-db-manager/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterEngineRoutes mounts the legacy, body-based database management
+// routes for an engine on group. Every route is available both for the
+// engine's default target and for a named target under /:target.
+//
+// Deprecated: these routes are kept for existing clients and answer with a
+// Deprecation header pointing at their /v1 equivalent; new clients should
+// use the routes of RegisterV1Routes.
+func RegisterEngineRoutes(group *gin.RouterGroup, m *manager.Manager, confirmations *Confirmations, engineName string) {
+	group.Use(deprecated())
+	group.GET("/targets", ListTargetsHandler(m, engineName))
+	registerDatabaseRoutes(group, m, confirmations, engineName)
+	registerDatabaseRoutes(group.Group("/:target"), m, confirmations, engineName)
+}
+
+func registerDatabaseRoutes(group *gin.RouterGroup, m *manager.Manager, confirmations *Confirmations, engineName string) {
+	confirm := Confirm(confirmations, engineName)
+	group.GET("/ping", PingHandler(m, engineName))
+	group.GET("/databases", ListDatabasesHandler(m, engineName))
+	group.POST("/databases", CreateDatabaseHandler(m, engineName))
+	group.PATCH("/databases/:dbName/credentials", ResetCredentialsHandler(m, engineName))
+	group.PATCH("/databases/:dbName", confirm, RenameDatabaseHandler(m, engineName))
+	group.GET("/databases/:dbName", DescribeDatabaseHandler(m, engineName))
+	group.DELETE("/databases/:dbName", confirm, DeleteDatabaseHandler(m, engineName))
+	group.GET("/databases/:dbName/stats", ViewDatabaseStatsHandler(m, engineName))
+
+	if engine, err := m.Registry().Get(engineName); err == nil {
+		if _, ok := engine.(database.QueryActivityReporter); ok {
+			group.GET("/databases/queries", TotalQueriesHandler(m, engineName))
+		}
+	}
+}
+
+// deprecated marks responses of the legacy routes as deprecated (RFC 9745)
+// and links to the versioned API.
+func deprecated() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Link", "</v1"+c.Request.URL.Path+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
+
+// targetRef builds the manager reference for the request: the engine alone
+// selects its default target, /:target routes select a named one.
+func targetRef(c *gin.Context, engineName string) string {
+	if target := c.Param("target"); target != "" {
+		return engineName + "/" + target
+	}
+	return engineName
+}
+
+// bindRequest binds the JSON body, writing the error response itself when
+// it cannot be decoded. Validation is left to the manager.
+func bindRequest(c *gin.Context, engineName string, requestBody interface{}, startTime int64) bool {
+	if err := c.BindJSON(requestBody); err != nil {
+		respondError(c, invalidArgument(engineName+"-bind-json", err), startTime, engineName+"-bind-json")
+		return false
+	}
+	return true
+}
+
+func respond(c *gin.Context, data map[string]interface{}, err error, startTime int64, action, message string) {
+	if err != nil {
+		respondError(c, err, startTime, action)
+		return
+	}
+	utils.SuccessResponse(c, data, startTime, action, message)
+}
+
+func credentialsData(creds *database.Credentials) map[string]interface{} {
+	if creds == nil {
+		return nil
+	}
+	data := map[string]interface{}{
+		"username": creds.Username,
+		"password": creds.Password,
+	}
+	if creds.DatabaseName != "" {
+		data["database_name"] = creds.DatabaseName
+	}
+	if creds.Access != "" {
+		data["access"] = creds.Access
+	}
+	if creds.Target != "" {
+		data["target"] = creds.Target
+	}
+	if creds.Steps != nil {
+		data["steps"] = creds.Steps
+	}
+	if creds.RetireAt != nil {
+		data["retire_at"] = creds.RetireAt
+	}
+	return data
+}
+
+func renameData(result *database.RenameResult) map[string]interface{} {
+	if result == nil {
+		return nil
+	}
+	data := map[string]interface{}{
+		"old_database_name": result.OldDatabaseName,
+		"new_database_name": result.NewDatabaseName,
+	}
+	if result.Users != nil {
+		data["users"] = result.Users
+	}
+	if result.PasswordsReset {
+		data["passwords_reset"] = true
+	}
+	if result.Moved != nil {
+		data["moved"] = result.Moved
+	}
+	if result.Steps != nil {
+		data["steps"] = result.Steps
+	}
+	return data
+}
+
+func deleteData(result *manager.DeleteResult) map[string]interface{} {
+	if result == nil {
+		return nil
+	}
+	data := map[string]interface{}{
+		"database_name": result.DatabaseName,
+	}
+	if result.RecycledAs != "" {
+		data["recycled_as"] = result.RecycledAs
+		data["purge_at"] = result.PurgeAt
+		if result.PasswordsReset {
+			data["passwords_reset"] = true
+		}
+	}
+	return data
+}
+
+func statsData(stats *database.DatabaseStats) map[string]interface{} {
+	if stats == nil {
+		return nil
+	}
+	data := map[string]interface{}{
+		"database_name": stats.DatabaseName,
+		"stats":         stats.Tables,
+	}
+	if stats.Collections != nil {
+		data["stats"] = stats.Collections
+	}
+	return data
+}
+
+func CreateDatabaseHandler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		var opts database.CreateOptions
+		if !bindRequest(c, engineName, &opts, startTime) {
+			return
+		}
+
+		creds, err := m.CreateDatabase(c.Request.Context(), targetRef(c, engineName), opts)
+		respond(c, credentialsData(creds), err, startTime, engineName+"-create-database", "Database Created")
+	}
+}
+
+func ResetCredentialsHandler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		var opts database.ResetCredentialsOptions
+		if !bindRequest(c, engineName, &opts, startTime) {
+			return
+		}
+
+		creds, err := m.ResetCredentials(c.Request.Context(), targetRef(c, engineName), opts)
+		respond(c, credentialsData(creds), err, startTime, engineName+"-reset-credentials", "Database Credentials Reset")
+	}
+}
+
+func RenameDatabaseHandler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		var opts database.RenameOptions
+		if !bindRequest(c, engineName, &opts, startTime) {
+			return
+		}
+
+		result, err := m.RenameDatabase(c.Request.Context(), targetRef(c, engineName), opts)
+		respond(c, renameData(result), err, startTime, engineName+"-rename-database", "Database Renamed")
+	}
+}
+
+func DeleteDatabaseHandler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		var opts database.DeleteOptions
+		if !bindRequest(c, engineName, &opts, startTime) {
+			return
+		}
+
+		result, err := m.DeleteDatabase(c.Request.Context(), targetRef(c, engineName), opts)
+		respond(c, deleteData(result), err, startTime, engineName+"-delete-database", "Database Deleted")
+	}
+}
+
+func ViewDatabaseStatsHandler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		var opts database.DatabaseOptions
+		if !bindRequest(c, engineName, &opts, startTime) {
+			return
+		}
+
+		stats, err := m.DatabaseStats(c.Request.Context(), targetRef(c, engineName), opts)
+		respond(c, statsData(stats), err, startTime, engineName+"-view-database-stats", "Database Statistics Retrieved")
+	}
+}
+
+func ListDatabasesHandler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		opts, err := listOptions(c)
+		if err != nil {
+			respondError(c, invalidArgument(engineName+"-bind-query", err), startTime, engineName+"-bind-query")
+			return
+		}
+
+		list, err := m.ListDatabases(c.Request.Context(), targetRef(c, engineName), opts)
+		var data map[string]interface{}
+		if list != nil {
+			data = map[string]interface{}{
+				"databases": list.Databases,
+				"total":     list.Total,
+				"limit":     list.Limit,
+				"offset":    list.Offset,
+			}
+		}
+		respond(c, data, err, startTime, engineName+"-list-databases", "Databases Listed")
+	}
+}
+
+// listOptions reads the list filters from the query string: prefix, owner,
+// recycled, limit, offset and any number of label=key=value.
+func listOptions(c *gin.Context) (manager.ListOptions, error) {
+	opts := manager.ListOptions{
+		Prefix:   c.Query("prefix"),
+		Owner:    c.Query("owner"),
+		Recycled: c.Query("recycled") == "true",
+	}
+	var err error
+	if value := c.Query("limit"); value != "" {
+		if opts.Limit, err = strconv.Atoi(value); err != nil {
+			return opts, fmt.Errorf("invalid limit %q", value)
+		}
+	}
+	if value := c.Query("offset"); value != "" {
+		if opts.Offset, err = strconv.Atoi(value); err != nil {
+			return opts, fmt.Errorf("invalid offset %q", value)
+		}
+	}
+	for _, label := range c.QueryArray("label") {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || key == "" {
+			return opts, fmt.Errorf("invalid label %q, expected key=value", label)
+		}
+		if opts.Labels == nil {
+			opts.Labels = make(map[string]string)
+		}
+		opts.Labels[key] = value
+	}
+	return opts, nil
+}
+
+func DescribeDatabaseHandler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		opts := database.DatabaseOptions{DatabaseName: c.Param("dbName")}
+
+		description, err := m.DescribeDatabase(c.Request.Context(), targetRef(c, engineName), opts)
+		var data map[string]interface{}
+		if description != nil {
+			data = map[string]interface{}{
+				"database": description,
+			}
+		}
+		respond(c, data, err, startTime, engineName+"-describe-database", "Database Described")
+	}
+}
+
+func PingHandler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		err := m.Ping(c.Request.Context(), targetRef(c, engineName))
+		respond(c, map[string]interface{}{
+			"engine": engineName,
+		}, err, startTime, engineName+"-ping", "Database Server Reachable")
+	}
+}
+
+func TotalQueriesHandler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		activities, err := m.TotalQueries(c.Request.Context(), targetRef(c, engineName))
+		respond(c, map[string]interface{}{
+			"user_activities": activities,
+		}, err, startTime, engineName+"-get-total-queries", "Database Query retrieved successfully")
+	}
+}
+
+func ListTargetsHandler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		utils.SuccessResponse(c, map[string]interface{}{
+			"targets": m.Targets(engineName),
+		}, startTime, engineName+"-list-targets", "Targets Listed")
+	}
+}
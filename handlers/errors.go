@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/bonheur15/go-db-manager/database"
+	"github.com/bonheur15/go-db-manager/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// respondError writes err with the status of its code, as a problem
+// document when the client accepts application/problem+json.
+func respondError(c *gin.Context, err error, startTime int64, action string) {
+	code := database.CodeOf(err)
+	status := httpStatus(code, err)
+	action = database.ActionOf(err, action)
+
+	// Operations that failed part-way report which steps ran and were
+	// undone, and validation failures every field that failed and why. A
+	// step can fail validation, so both may be reported.
+	var data map[string]interface{}
+	if steps := database.StepsOf(err); steps != nil {
+		data = map[string]interface{}{"steps": steps}
+	}
+	if fields := database.FieldsOf(err); fields != nil {
+		if data == nil {
+			data = make(map[string]interface{})
+		}
+		data["fields"] = fields
+	}
+	writeError(c, status, code, err, data, startTime, action)
+}
+
+// writeError writes an error response with data, as a problem document
+// when the client accepts application/problem+json.
+func writeError(c *gin.Context, status int, code database.Code, err error, data map[string]interface{}, startTime int64, action string) {
+	if strings.Contains(c.GetHeader("Accept"), "application/problem+json") {
+		utils.ProblemResponse(c, status, string(code), err, data, action)
+		return
+	}
+	utils.ErrorResponse(c, status, string(code), err, data, startTime, action)
+}
+
+func httpStatus(code database.Code, err error) int {
+	switch code {
+	case database.CodeNotFound:
+		return http.StatusNotFound
+	case database.CodeConflict:
+		return http.StatusConflict
+	case database.CodeInvalidArgument:
+		return http.StatusUnprocessableEntity
+	case database.CodePermissionDenied:
+		return http.StatusForbidden
+	case database.CodeUpstreamUnavailable:
+		return http.StatusServiceUnavailable
+	case database.CodeConfirmationRequired:
+		return http.StatusPreconditionRequired
+	}
+	// An error the database server returned that has no better code
+	// is the upstream's failure, not ours.
+	if database.IsServerError(err) {
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// invalidArgument marks request decoding errors so they are reported as
+// CodeInvalidArgument under action.
+func invalidArgument(action string, err error) error {
+	return &database.OpError{Action: action, Code: database.CodeInvalidArgument, Err: err}
+}
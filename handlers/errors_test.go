@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bonheur15/go-db-manager/database"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRespondErrorKeepsStepsAndFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	validationErr := &database.ValidationError{Fields: []database.FieldError{{Field: "options.collections[0].name", Rule: "collection", Message: "is invalid"}}}
+	err := &database.StepsError{
+		Steps: []database.Step{{Name: "create-database", Status: database.StepCompensated}, {Name: "create-collections", Status: database.StepFailed}},
+		Err:   &database.OpError{Action: "mongo-create-collections", Code: database.CodeInvalidArgument, Err: validationErr},
+	}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("POST", "/v1/mongo/databases", nil)
+	respondError(c, err, 0, "mongo-create-database")
+
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want 422", recorder.Code)
+	}
+	var body struct {
+		Data struct {
+			Steps  []database.Step       `json:"steps"`
+			Fields []database.FieldError `json:"fields"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Data.Steps) != 2 || len(body.Data.Fields) != 1 {
+		t.Errorf("data = %+v, want both the steps and the fields", body.Data)
+	}
+}
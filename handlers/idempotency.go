@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bonheur15/go-db-manager/database"
+	"github.com/bonheur15/go-db-manager/idempotency"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// Idempotency honors the Idempotency-Key header of mutating requests: the
+// first request with a key runs and its response is stored, retries with
+// the same key and body get the stored response back.
+func Idempotency(store *idempotency.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" || !isMutating(c.Request.Method) {
+			c.Next()
+			return
+		}
+		startTime := time.Now().UnixMilli()
+		if len(key) > maxIdempotencyKeyLength {
+			respondError(c, invalidArgument("idempotency-key", fmt.Errorf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength)), startTime, "idempotency-key")
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			respondError(c, invalidArgument("idempotency-read-body", err), startTime, "idempotency-read-body")
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		stored, err := store.Begin(key, fingerprint(c.Request, body))
+		if err != nil {
+			respondError(c, idempotencyError(err), startTime, "idempotency-key")
+			c.Abort()
+			return
+		}
+		if stored != nil {
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(stored.Status, stored.ContentType, stored.Body)
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		// Server errors are transient and unconfirmed requests did not run:
+		// let the client retry them for real.
+		if status := recorder.Status(); status >= http.StatusInternalServerError || status == http.StatusPreconditionRequired {
+			err = store.Release(key)
+		} else {
+			err = store.Complete(key, idempotency.Response{
+				Status:      status,
+				ContentType: recorder.Header().Get("Content-Type"),
+				Body:        recorder.body.Bytes(),
+			})
+		}
+		if err != nil {
+			log.Error().Err(err).Str("action", "idempotency-store-response").Msg(err.Error())
+		}
+	}
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// fingerprint identifies a request by method, path, query and body, so
+// that a key reused for anything else is detected. The query is encoded
+// with its parameters sorted, so that their order does not matter, but
+// flags such as ?permanent=true do.
+func fingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s?%s\n", r.Method, r.URL.Path, r.URL.Query().Encode())
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func idempotencyError(err error) error {
+	code := database.CodeInternal
+	if errors.Is(err, idempotency.ErrMismatch) || errors.Is(err, idempotency.ErrInProgress) {
+		code = database.CodeConflict
+	}
+	return &database.OpError{Action: "idempotency-key", Code: code, Err: err}
+}
+
+// responseRecorder keeps a copy of the response body for the store.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
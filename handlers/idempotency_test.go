@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bonheur15/go-db-manager/idempotency"
+	"github.com/bonheur15/go-db-manager/utils"
+	"github.com/gin-gonic/gin"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestFingerprint(t *testing.T) {
+	base := fingerprint(httptest.NewRequest("DELETE", "/v1/mysql/databases/shop?permanent=true&wait=5", nil), []byte(`{}`))
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		same   bool
+	}{
+		{"same request", "DELETE", "/v1/mysql/databases/shop?permanent=true&wait=5", `{}`, true},
+		{"query parameters reordered", "DELETE", "/v1/mysql/databases/shop?wait=5&permanent=true", `{}`, true},
+		{"query differs", "DELETE", "/v1/mysql/databases/shop?permanent=false&wait=5", `{}`, false},
+		{"query missing", "DELETE", "/v1/mysql/databases/shop", `{}`, false},
+		{"path differs", "DELETE", "/v1/mysql/databases/other?permanent=true&wait=5", `{}`, false},
+		{"method differs", "POST", "/v1/mysql/databases/shop?permanent=true&wait=5", `{}`, false},
+		{"body differs", "DELETE", "/v1/mysql/databases/shop?permanent=true&wait=5", `{"force":true}`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fp := fingerprint(httptest.NewRequest(test.method, test.target, nil), []byte(test.body))
+			if (fp == base) != test.same {
+				t.Errorf("fingerprint equal = %v, want %v", fp == base, test.same)
+			}
+		})
+	}
+}
+
+func newIdempotencyRouter(t *testing.T, runs *int) *gin.Engine {
+	t.Helper()
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "keys.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	cipher, err := utils.NewCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := idempotency.New(db, cipher, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Idempotency(store))
+	router.DELETE("/databases/:dbName", func(c *gin.Context) {
+		*runs++
+		c.JSON(http.StatusOK, gin.H{"run": *runs})
+	})
+	return router
+}
+
+func serve(router *gin.Engine, method, target, key string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, nil)
+	request.Header.Set("Idempotency-Key", key)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestIdempotencyReplays(t *testing.T) {
+	runs := 0
+	router := newIdempotencyRouter(t, &runs)
+
+	first := serve(router, "DELETE", "/databases/shop?permanent=true", "key-1")
+	retry := serve(router, "DELETE", "/databases/shop?permanent=true", "key-1")
+	if runs != 1 {
+		t.Fatalf("the handler ran %d times, want once", runs)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry = %d %s, want the stored response replayed", retry.Code, retry.Body)
+	}
+}
+
+func TestIdempotencyRejectsReusedKey(t *testing.T) {
+	runs := 0
+	router := newIdempotencyRouter(t, &runs)
+
+	serve(router, "DELETE", "/databases/shop", "key-1")
+	// The same key with another query string is another request.
+	reused := serve(router, "DELETE", "/databases/shop?permanent=true", "key-1")
+	if runs != 1 || reused.Code != http.StatusConflict {
+		t.Errorf("reused key = %d after %d runs, want 409 after 1", reused.Code, runs)
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bonheur15/go-db-manager/database"
+	"github.com/bonheur15/go-db-manager/jobs"
+	"github.com/bonheur15/go-db-manager/manager"
+	"github.com/bonheur15/go-db-manager/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// jobParams is what a database job needs to run: the target reference and
+// the operation's options.
+type jobParams[T any] struct {
+	Ref     string `json:"ref"`
+	Options T      `json:"options"`
+}
+
+// RegisterJobKinds makes the database operations that can run
+// asynchronously available to runner.
+func RegisterJobKinds(runner *jobs.Runner, m *manager.Manager) {
+	runner.Register("create-database", jobs.Kind{
+		Run: jobFunc(func(ctx context.Context, p jobParams[database.CreateOptions]) (interface{}, error) {
+			creds, err := m.CreateDatabase(ctx, p.Ref, p.Options)
+			return credentialsData(creds), err
+		}),
+	})
+	runner.Register("rename-database", jobs.Kind{
+		Run: jobFunc(func(ctx context.Context, p jobParams[database.RenameOptions]) (interface{}, error) {
+			result, err := m.RenameDatabase(ctx, p.Ref, p.Options)
+			return renameData(result), err
+		}),
+	})
+	// Deletes are not resumable: a recycle interrupted between renaming the
+	// database and recording it in the catalog would fail with not_found
+	// when run again and leave an uncatalogued tombstone behind, so the
+	// interrupted job is reported as failed instead.
+	runner.Register("delete-database", jobs.Kind{
+		Run: jobFunc(func(ctx context.Context, p jobParams[database.DeleteOptions]) (interface{}, error) {
+			result, err := m.DeleteDatabase(ctx, p.Ref, p.Options)
+			return deleteData(result), err
+		}),
+	})
+	runner.Register("restore-database", jobs.Kind{
+		Run: jobFunc(func(ctx context.Context, p jobParams[database.DatabaseOptions]) (interface{}, error) {
+			result, err := m.RestoreDatabase(ctx, p.Ref, p.Options)
+			return renameData(result), err
+		}),
+	})
+}
+
+func jobFunc[T any](run func(ctx context.Context, params jobParams[T]) (interface{}, error)) jobs.Func {
+	return func(ctx context.Context, data json.RawMessage) (interface{}, error) {
+		var params jobParams[T]
+		if err := json.Unmarshal(data, &params); err != nil {
+			return nil, err
+		}
+		return run(ctx, params)
+	}
+}
+
+// RegisterJobRoutes mounts the job status routes on group.
+func RegisterJobRoutes(group *gin.RouterGroup, runner *jobs.Runner) {
+	group.GET("/jobs/:id", GetJobHandler(runner))
+	group.POST("/jobs/:id/cancel", CancelJobHandler(runner))
+}
+
+// wantsAsync reports whether the client asked for the operation to run as
+// a job, with Prefer: respond-async (RFC 7240) or ?async=true.
+func wantsAsync(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Prefer"), "respond-async") || c.Query("async") == "true"
+}
+
+// submitJob answers 202 Accepted with the job that runs kind, if the client
+// asked for it. It reports whether the request was handled.
+func submitJob[T any](c *gin.Context, runner *jobs.Runner, kind, ref string, opts T, startTime int64, action string) bool {
+	if runner == nil || !wantsAsync(c) {
+		return false
+	}
+	engineName, _ := database.ParseRef(ref)
+	if err := database.ValidateFor(engineName, opts); err != nil {
+		respondError(c, invalidArgument(engineName+"-validation", err), startTime, action)
+		return true
+	}
+
+	job, err := runner.Submit(kind, jobParams[T]{Ref: ref, Options: opts})
+	if err != nil {
+		respondError(c, err, startTime, action)
+		return true
+	}
+	c.Header("Location", "/v1/jobs/"+job.ID)
+	utils.AcceptedResponse(c, map[string]interface{}{
+		"job": job,
+	}, startTime, action, "Job Submitted")
+	return true
+}
+
+func GetJobHandler(runner *jobs.Runner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		job, err := runner.Get(c.Param("id"))
+		respond(c, map[string]interface{}{
+			"job": job,
+		}, jobError("get-job", err), startTime, "get-job", "Job Retrieved")
+	}
+}
+
+func CancelJobHandler(runner *jobs.Runner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		job, err := runner.Cancel(c.Param("id"))
+		respond(c, map[string]interface{}{
+			"job": job,
+		}, jobError("cancel-job", err), startTime, "cancel-job", "Job Cancellation Requested")
+	}
+}
+
+func jobError(action string, err error) error {
+	if errors.Is(err, jobs.ErrNotFound) {
+		return &database.OpError{Action: action, Code: database.CodeNotFound, Err: err}
+	}
+	return err
+}
@@ -13,6 +13,7 @@ func GetServerInfoHandler(c *gin.Context) {
 	if err != nil {
 		c.JSON(500, gin.H{
 			"error":           true,
+			"code":            "internal",
 			"message":         err.Error(),
 			"action":          "server-info",
 			"timestamp":       time.Now(),
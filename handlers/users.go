@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/bonheur15/go-db-manager/database"
+	"github.com/bonheur15/go-db-manager/manager"
+	"github.com/gin-gonic/gin"
+)
+
+// registerV1UserRoutes mounts the user routes of a database on group.
+// Removing a user needs a confirmation token when confirmations require
+// one for the engine.
+func registerV1UserRoutes(group *gin.RouterGroup, m *manager.Manager, confirm gin.HandlerFunc, engineName string) {
+	group.GET("/databases/:dbName/users", ListUsersHandler(m, engineName))
+	group.POST("/databases/:dbName/users", AddUserHandler(m, engineName))
+	group.DELETE("/databases/:dbName/users/:username", confirm, RemoveUserHandler(m, engineName))
+	group.POST("/databases/:dbName/users/:username/password", ResetPasswordHandler(m, engineName))
+}
+
+// registerV1RotationRoutes mounts the credential rotation routes of a
+// database's users on group.
+func registerV1RotationRoutes(group *gin.RouterGroup, m *manager.Manager, engineName string) {
+	group.POST("/databases/:dbName/users/:username/rotate", RotateCredentialsHandler(m, engineName))
+	group.POST("/databases/:dbName/users/:username/rotate/confirm", ConfirmRotationHandler(m, engineName))
+}
+
+// addUserRequest is the optional body of
+// POST /v1/{engine}/databases/:dbName/users.
+type addUserRequest struct {
+	Username string          `json:"username"`
+	Access   database.Access `json:"access"`
+}
+
+// rotateRequest is the optional body of
+// POST /v1/{engine}/databases/:dbName/users/:username/rotate.
+type rotateRequest struct {
+	GraceSeconds *int `json:"grace_seconds"`
+}
+
+func ListUsersHandler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		opts := database.DatabaseOptions{DatabaseName: c.Param("dbName")}
+
+		users, err := m.Users(c.Request.Context(), targetRef(c, engineName), opts)
+		respond(c, map[string]interface{}{
+			"database_name": opts.DatabaseName,
+			"users":         users,
+		}, err, startTime, engineName+"-list-users", "Users Listed")
+	}
+}
+
+func AddUserHandler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		var body addUserRequest
+		if c.Request.ContentLength != 0 && !bindRequest(c, engineName, &body, startTime) {
+			return
+		}
+
+		opts := database.UserOptions{
+			DatabaseName: c.Param("dbName"),
+			Username:     body.Username,
+			Access:       body.Access,
+		}
+		creds, err := m.AddUser(c.Request.Context(), targetRef(c, engineName), opts)
+		respond(c, credentialsData(creds), err, startTime, engineName+"-add-user", "User Added")
+	}
+}
+
+func RemoveUserHandler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		opts := database.UserOptions{DatabaseName: c.Param("dbName"), Username: c.Param("username")}
+
+		err := m.RemoveUser(c.Request.Context(), targetRef(c, engineName), opts)
+		respond(c, map[string]interface{}{
+			"database_name": opts.DatabaseName,
+			"username":      opts.Username,
+		}, err, startTime, engineName+"-remove-user", "User Removed")
+	}
+}
+
+func ResetPasswordHandler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		opts := database.UserOptions{DatabaseName: c.Param("dbName"), Username: c.Param("username")}
+
+		creds, err := m.ResetPassword(c.Request.Context(), targetRef(c, engineName), opts)
+		respond(c, credentialsData(creds), err, startTime, engineName+"-reset-password", "Password Reset")
+	}
+}
+
+func RotateCredentialsHandler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		var body rotateRequest
+		if c.Request.ContentLength != 0 && !bindRequest(c, engineName, &body, startTime) {
+			return
+		}
+
+		opts := manager.RotateOptions{
+			DatabaseName: c.Param("dbName"),
+			Username:     c.Param("username"),
+			GraceSeconds: body.GraceSeconds,
+		}
+		rotation, err := m.RotateCredentials(c.Request.Context(), targetRef(c, engineName), opts)
+		respond(c, rotationData(rotation), err, startTime, engineName+"-rotate-credentials", "Rotation Started")
+	}
+}
+
+func ConfirmRotationHandler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		opts := database.UserOptions{DatabaseName: c.Param("dbName"), Username: c.Param("username")}
+
+		rotation, err := m.ConfirmRotation(c.Request.Context(), targetRef(c, engineName), opts)
+		respond(c, rotationData(rotation), err, startTime, engineName+"-confirm-rotation", "Rotation Confirmed")
+	}
+}
+
+func rotationData(rotation *database.Rotation) map[string]interface{} {
+	if rotation == nil {
+		return nil
+	}
+	data := map[string]interface{}{
+		"database_name":    rotation.DatabaseName,
+		"username":         rotation.Username,
+		"new_username":     rotation.NewUsername,
+		"retired_username": rotation.RetiredUsername,
+	}
+	if rotation.Password != "" {
+		data["password"] = rotation.Password
+	}
+	if rotation.RetireAt != nil {
+		data["retire_at"] = rotation.RetireAt
+	}
+	return data
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/bonheur15/go-db-manager/database"
+	"github.com/bonheur15/go-db-manager/jobs"
+	"github.com/bonheur15/go-db-manager/manager"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterV1Routes mounts the versioned API for an engine on group. Unlike
+// the legacy routes, the path identifies the database and GET and DELETE
+// requests have no body.
+//
+// Creates, renames, deletes and restores run as jobs on runner when the client asks
+// for it; runner may be nil to always run them in the request. Renames,
+// deletes and user removals need a confirmation token when confirmations
+// require one for the engine; confirmations may be nil.
+func RegisterV1Routes(group *gin.RouterGroup, m *manager.Manager, runner *jobs.Runner, confirmations *Confirmations, engineName string) {
+	group.GET("/targets", ListTargetsHandler(m, engineName))
+	registerV1DatabaseRoutes(group, m, runner, confirmations, engineName)
+	registerV1DatabaseRoutes(group.Group("/:target"), m, runner, confirmations, engineName)
+}
+
+func registerV1DatabaseRoutes(group *gin.RouterGroup, m *manager.Manager, runner *jobs.Runner, confirmations *Confirmations, engineName string) {
+	confirm := Confirm(confirmations, engineName)
+	group.GET("/ping", PingHandler(m, engineName))
+	group.GET("/databases", ListDatabasesHandler(m, engineName))
+	group.POST("/databases", CreateDatabaseV1Handler(m, runner, engineName))
+	group.GET("/databases/:dbName", DescribeDatabaseHandler(m, engineName))
+	group.PATCH("/databases/:dbName", confirm, RenameDatabaseV1Handler(m, runner, engineName))
+	group.DELETE("/databases/:dbName", confirm, DeleteDatabaseV1Handler(m, runner, engineName))
+	group.POST("/databases/:dbName/restore", RestoreDatabaseV1Handler(m, runner, engineName))
+	group.GET("/databases/:dbName/stats", ViewDatabaseStatsV1Handler(m, engineName))
+	group.PATCH("/databases/:dbName/credentials", ResetCredentialsV1Handler(m, engineName))
+	group.PUT("/databases/:dbName/deletion-protection", SetDeletionProtectionV1Handler(m, engineName))
+
+	if engine, err := m.Registry().Get(engineName); err == nil {
+		if _, ok := engine.(database.QueryActivityReporter); ok {
+			group.GET("/queries", TotalQueriesHandler(m, engineName))
+		}
+		if _, ok := engine.(database.UserManager); ok {
+			registerV1UserRoutes(group, m, confirm, engineName)
+		}
+		if _, ok := engine.(database.CredentialRotator); ok {
+			registerV1RotationRoutes(group, m, engineName)
+		}
+	}
+}
+
+// renameRequest is the body of PATCH /v1/{engine}/databases/:dbName.
+type renameRequest struct {
+	Name        string `json:"name"`
+	Force       bool   `json:"force"`
+	WaitSeconds int    `json:"wait_seconds"`
+}
+
+// deletionProtectionRequest is the body of
+// PUT /v1/{engine}/databases/:dbName/deletion-protection.
+type deletionProtectionRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// resetCredentialsRequest is the optional body of
+// PATCH /v1/{engine}/databases/:dbName/credentials.
+type resetCredentialsRequest struct {
+	Username     string          `json:"username"`
+	Access       database.Access `json:"access"`
+	GraceSeconds *int            `json:"grace_seconds"`
+}
+
+func CreateDatabaseV1Handler(m *manager.Manager, runner *jobs.Runner, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		var opts database.CreateOptions
+		if !bindRequest(c, engineName, &opts, startTime) {
+			return
+		}
+
+		ref, action := targetRef(c, engineName), engineName+"-create-database"
+		if submitJob(c, runner, "create-database", ref, opts, startTime, action) {
+			return
+		}
+		creds, err := m.CreateDatabase(c.Request.Context(), ref, opts)
+		respond(c, credentialsData(creds), err, startTime, action, "Database Created")
+	}
+}
+
+func RenameDatabaseV1Handler(m *manager.Manager, runner *jobs.Runner, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		var body renameRequest
+		if !bindRequest(c, engineName, &body, startTime) {
+			return
+		}
+
+		opts := database.RenameOptions{
+			OldDatabaseName: c.Param("dbName"),
+			NewDatabaseName: body.Name,
+			Force:           body.Force,
+			WaitSeconds:     body.WaitSeconds,
+		}
+		ref, action := targetRef(c, engineName), engineName+"-rename-database"
+		if submitJob(c, runner, "rename-database", ref, opts, startTime, action) {
+			return
+		}
+		result, err := m.RenameDatabase(c.Request.Context(), ref, opts)
+		respond(c, renameData(result), err, startTime, action, "Database Renamed")
+	}
+}
+
+func DeleteDatabaseV1Handler(m *manager.Manager, runner *jobs.Runner, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		opts := database.DeleteOptions{DatabaseName: c.Param("dbName"), Permanent: c.Query("permanent") == "true"}
+
+		ref, action := targetRef(c, engineName), engineName+"-delete-database"
+		if submitJob(c, runner, "delete-database", ref, opts, startTime, action) {
+			return
+		}
+		result, err := m.DeleteDatabase(c.Request.Context(), ref, opts)
+		respond(c, deleteData(result), err, startTime, action, "Database Deleted")
+	}
+}
+
+func RestoreDatabaseV1Handler(m *manager.Manager, runner *jobs.Runner, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		opts := database.DatabaseOptions{DatabaseName: c.Param("dbName")}
+
+		ref, action := targetRef(c, engineName), engineName+"-restore-database"
+		if submitJob(c, runner, "restore-database", ref, opts, startTime, action) {
+			return
+		}
+		result, err := m.RestoreDatabase(c.Request.Context(), ref, opts)
+		respond(c, renameData(result), err, startTime, action, "Database Restored")
+	}
+}
+
+func ViewDatabaseStatsV1Handler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		opts := database.DatabaseOptions{DatabaseName: c.Param("dbName")}
+
+		stats, err := m.DatabaseStats(c.Request.Context(), targetRef(c, engineName), opts)
+		respond(c, statsData(stats), err, startTime, engineName+"-view-database-stats", "Database Statistics Retrieved")
+	}
+}
+
+func ResetCredentialsV1Handler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		var body resetCredentialsRequest
+		if c.Request.ContentLength != 0 && !bindRequest(c, engineName, &body, startTime) {
+			return
+		}
+
+		opts := database.ResetCredentialsOptions{
+			DatabaseName: c.Param("dbName"),
+			Username:     body.Username,
+			Access:       body.Access,
+			GraceSeconds: body.GraceSeconds,
+		}
+		creds, err := m.ResetCredentials(c.Request.Context(), targetRef(c, engineName), opts)
+		respond(c, credentialsData(creds), err, startTime, engineName+"-reset-credentials", "Database Credentials Reset")
+	}
+}
+
+func SetDeletionProtectionV1Handler(m *manager.Manager, engineName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now().UnixMilli()
+		var body deletionProtectionRequest
+		if !bindRequest(c, engineName, &body, startTime) {
+			return
+		}
+
+		opts := manager.DeletionProtectionOptions{DatabaseName: c.Param("dbName"), Enabled: body.Enabled}
+		err := m.SetDeletionProtection(c.Request.Context(), targetRef(c, engineName), opts)
+		respond(c, map[string]interface{}{
+			"database_name":       opts.DatabaseName,
+			"deletion_protection": opts.Enabled,
+		}, err, startTime, engineName+"-deletion-protection", "Deletion Protection Updated")
+	}
+}
@@ -0,0 +1,194 @@
+// Package idempotency stores the responses of mutating requests under the
+// Idempotency-Key their client sent, so that a retry gets the original
+// response instead of running the operation again. Response bodies carry
+// one-time passwords and are encrypted at rest.
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bonheur15/go-db-manager/utils"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// ErrMismatch is returned when a key is reused for a different request.
+	ErrMismatch = errors.New("idempotency key was already used for a different request")
+	// ErrInProgress is returned while the first request with a key runs.
+	ErrInProgress = errors.New("a request with this idempotency key is still in progress")
+)
+
+var keysBucket = []byte("idempotency_keys")
+
+// pendingTimeout bounds how long a claimed key blocks retries when the
+// request that claimed it never completed, e.g. because the process died.
+const pendingTimeout = 15 * time.Minute
+
+// Response is a stored HTTP response.
+type Response struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+type record struct {
+	Fingerprint string    `json:"fingerprint"`
+	Complete    bool      `json:"complete"`
+	Status      int       `json:"status,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Body        []byte    `json:"body,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type Store struct {
+	db     *bolt.DB
+	cipher *utils.Cipher
+	window time.Duration
+}
+
+// New keeps idempotency records in db for window, with response bodies
+// encrypted by cipher.
+func New(db *bolt.DB, cipher *utils.Cipher, window time.Duration) (*Store, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(keysBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("initializing idempotency store: %w", err)
+	}
+	return &Store{db: db, cipher: cipher, window: window}, nil
+}
+
+// Begin claims key for a request identified by fingerprint. It returns nil
+// when the request should run, the stored response when it already ran,
+// ErrInProgress while it is running and ErrMismatch when the key belongs
+// to another request.
+func (s *Store) Begin(key, fingerprint string) (*Response, error) {
+	var stored *record
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(keysBucket)
+		now := time.Now()
+		if data := bucket.Get([]byte(key)); data != nil {
+			var r record
+			if err := json.Unmarshal(data, &r); err != nil {
+				return err
+			}
+			abandoned := !r.Complete && now.Sub(r.CreatedAt) > pendingTimeout
+			if now.Before(r.ExpiresAt) && !abandoned {
+				stored = &r
+				return nil
+			}
+		}
+
+		data, err := json.Marshal(record{
+			Fingerprint: fingerprint,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(s.window),
+		})
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), data)
+	})
+	if err != nil || stored == nil {
+		return nil, err
+	}
+
+	switch {
+	case stored.Fingerprint != fingerprint:
+		return nil, ErrMismatch
+	case !stored.Complete:
+		return nil, ErrInProgress
+	}
+	body, err := s.cipher.Open(stored.Body)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting stored response: %w", err)
+	}
+	return &Response{Status: stored.Status, ContentType: stored.ContentType, Body: body}, nil
+}
+
+// Complete stores the response of the request that claimed key.
+func (s *Store) Complete(key string, response Response) error {
+	body, err := s.cipher.Seal(response.Body)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(keysBucket)
+		data := bucket.Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+		r.Complete = true
+		r.Status = response.Status
+		r.ContentType = response.ContentType
+		r.Body = body
+		if data, err = json.Marshal(r); err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), data)
+	})
+}
+
+// Release forgets key, so that a retry runs the request again. It is used
+// for responses that are not worth replaying, such as server errors.
+func (s *Store) Release(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(keysBucket).Delete([]byte(key))
+	})
+}
+
+// Purge deletes the records whose window has passed.
+func (s *Store) Purge() (int, error) {
+	var expired [][]byte
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(keysBucket)
+		now := time.Now()
+		err := bucket.ForEach(func(key, data []byte) error {
+			var r record
+			if err := json.Unmarshal(data, &r); err == nil && now.Before(r.ExpiresAt) {
+				return nil
+			}
+			expired = append(expired, append([]byte(nil), key...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return len(expired), err
+}
+
+// Run purges expired records every interval until ctx is done.
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if purged, err := s.Purge(); err != nil {
+				log.Error().Err(err).Msg("purging idempotency keys failed")
+			} else if purged > 0 {
+				log.Debug().Int("purged", purged).Msg("purged expired idempotency keys")
+			}
+		}
+	}
+}
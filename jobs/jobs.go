@@ -0,0 +1,456 @@
+// Package jobs runs long operations in the background. Jobs are persisted
+// in the service's bbolt file so that their status survives a restart:
+// jobs interrupted by a restart are run again when their kind is
+// resumable, and marked failed otherwise.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bonheur15/go-db-manager/database"
+	"github.com/bonheur15/go-db-manager/utils"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrNotFound = errors.New("job not found")
+
+var jobsBucket = []byte("jobs")
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCanceled  Status = "canceled"
+)
+
+func (s Status) finished() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCanceled
+}
+
+type Progress struct {
+	Done    int    `json:"done"`
+	Total   int    `json:"total"`
+	Message string `json:"message,omitempty"`
+}
+
+// Error is the failure of a job, classified like API errors.
+type Error struct {
+	Code    database.Code   `json:"code"`
+	Action  string          `json:"action"`
+	Message string          `json:"message"`
+	Steps   []database.Step `json:"steps,omitempty"`
+}
+
+type Job struct {
+	ID         string          `json:"id"`
+	Kind       string          `json:"kind"`
+	Status     Status          `json:"status"`
+	Progress   Progress        `json:"progress"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      *Error          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+// record is the stored form of a job. The result may hold credentials and
+// is encrypted.
+type record struct {
+	Job
+	Params json.RawMessage `json:"params"`
+	Sealed []byte          `json:"sealed_result,omitempty"`
+}
+
+// Func runs a job from its params and returns its result.
+type Func func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+type Kind struct {
+	Run Func
+	// Resumable kinds are run again from the start when a restart
+	// interrupted them. Only kinds that are safe to repeat after a partial
+	// run should set it.
+	Resumable bool
+}
+
+type Runner struct {
+	db     *bolt.DB
+	cipher *utils.Cipher
+	kinds  map[string]Kind
+	slots  chan struct{}
+	// retention is how long finished jobs are kept; zero keeps them.
+	retention time.Duration
+
+	mu       sync.Mutex
+	cancels  map[string]context.CancelFunc
+	closing  bool
+	wg       sync.WaitGroup
+	baseCtx  context.Context
+	stopJobs context.CancelFunc
+}
+
+// New returns a Runner keeping jobs in db, running at most concurrency
+// jobs at a time, with results encrypted by cipher. Finished jobs are
+// purged once retention has passed since they finished; zero keeps them.
+func New(db *bolt.DB, cipher *utils.Cipher, concurrency int, retention time.Duration) (*Runner, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("initializing jobs: %w", err)
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	baseCtx, stopJobs := context.WithCancel(context.Background())
+	return &Runner{
+		db:        db,
+		cipher:    cipher,
+		kinds:     make(map[string]Kind),
+		slots:     make(chan struct{}, concurrency),
+		retention: retention,
+		cancels:   make(map[string]context.CancelFunc),
+		baseCtx:   baseCtx,
+		stopJobs:  stopJobs,
+	}, nil
+}
+
+// Register makes a kind of job available. Kinds must be registered before
+// Resume and Submit are called.
+func (r *Runner) Register(name string, kind Kind) {
+	r.kinds[name] = kind
+}
+
+// Submit stores a new job of kind with params and starts it.
+func (r *Runner) Submit(kind string, params interface{}) (*Job, error) {
+	if _, ok := r.kinds[kind]; !ok {
+		return nil, fmt.Errorf("unknown job kind %q", kind)
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &record{
+		Job:    Job{ID: id, Kind: kind, Status: StatusQueued, CreatedAt: time.Now().UTC()},
+		Params: data,
+	}
+	if err := r.put(rec); err != nil {
+		return nil, err
+	}
+	if err := r.start(rec); err != nil {
+		return nil, err
+	}
+	return &rec.Job, nil
+}
+
+// Resume handles the jobs a previous process left unfinished.
+func (r *Runner) Resume() error {
+	var unfinished []*record
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(_, data []byte) error {
+			rec := &record{}
+			if err := json.Unmarshal(data, rec); err != nil {
+				return err
+			}
+			if !rec.Status.finished() {
+				unfinished = append(unfinished, rec)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range unfinished {
+		kind, ok := r.kinds[rec.Kind]
+		if !ok || !kind.Resumable {
+			now := time.Now().UTC()
+			rec.Status = StatusFailed
+			rec.FinishedAt = &now
+			rec.Error = &Error{
+				Code:    database.CodeInternal,
+				Action:  "job-interrupted",
+				Message: "the job was interrupted by a restart and may have been partially applied",
+			}
+			if err := r.put(rec); err != nil {
+				return err
+			}
+			continue
+		}
+		log.Info().Str("job", rec.ID).Str("kind", rec.Kind).Msg("resuming job")
+		rec.Status = StatusQueued
+		rec.Progress = Progress{}
+		if err := r.put(rec); err != nil {
+			return err
+		}
+		if err := r.start(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Runner) start(rec *record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closing {
+		return errors.New("the job runner is shutting down")
+	}
+
+	ctx, cancel := context.WithCancel(r.baseCtx)
+	r.cancels[rec.ID] = cancel
+	r.wg.Add(1)
+	go r.run(ctx, rec)
+	return nil
+}
+
+func (r *Runner) run(ctx context.Context, rec *record) {
+	defer r.wg.Done()
+	defer func() {
+		r.mu.Lock()
+		r.cancels[rec.ID]()
+		delete(r.cancels, rec.ID)
+		r.mu.Unlock()
+	}()
+
+	select {
+	case r.slots <- struct{}{}:
+		defer func() { <-r.slots }()
+	case <-ctx.Done():
+		r.finish(ctx, rec.ID, nil, ctx.Err())
+		return
+	}
+
+	now := time.Now().UTC()
+	if err := r.update(rec.ID, func(rec *record) {
+		rec.Status = StatusRunning
+		rec.StartedAt = &now
+	}); err != nil {
+		log.Error().Err(err).Str("job", rec.ID).Msg("updating job failed")
+	}
+
+	ctx = database.WithProgress(ctx, func(done, total int, message string) {
+		err := r.update(rec.ID, func(rec *record) {
+			rec.Progress = Progress{Done: done, Total: total, Message: message}
+		})
+		if err != nil {
+			log.Error().Err(err).Str("job", rec.ID).Msg("updating job progress failed")
+		}
+	})
+	result, err := r.kinds[rec.Kind].Run(ctx, rec.Params)
+	r.finish(ctx, rec.ID, result, err)
+}
+
+// finish records the outcome of a job. A failure after ctx was canceled is
+// a cancellation, whatever error the driver returned for it; a job stopped
+// by Shutdown is left unfinished, for Resume to pick up after the restart.
+func (r *Runner) finish(ctx context.Context, id string, result interface{}, err error) {
+	canceled := err != nil && ctx.Err() != nil
+	if canceled && r.baseCtx.Err() != nil {
+		return
+	}
+
+	var sealed []byte
+	if err == nil {
+		data, marshalErr := json.Marshal(result)
+		if marshalErr == nil {
+			sealed, marshalErr = r.cipher.Seal(data)
+		}
+		err = marshalErr
+	}
+
+	updateErr := r.update(id, func(rec *record) {
+		now := time.Now().UTC()
+		rec.FinishedAt = &now
+		switch {
+		case err == nil:
+			rec.Status = StatusSucceeded
+			rec.Sealed = sealed
+		case canceled:
+			rec.Status = StatusCanceled
+		default:
+			rec.Status = StatusFailed
+			rec.Error = &Error{
+				Code:    database.CodeOf(err),
+				Action:  database.ActionOf(err, rec.Kind),
+				Message: err.Error(),
+				Steps:   database.StepsOf(err),
+			}
+		}
+	})
+	if updateErr != nil {
+		log.Error().Err(updateErr).Str("job", id).Msg("recording job result failed")
+	}
+}
+
+// Get returns a job with its decrypted result.
+func (r *Runner) Get(id string) (*Job, error) {
+	rec, err := r.get(id)
+	if err != nil {
+		return nil, err
+	}
+	job := rec.Job
+	if rec.Sealed != nil {
+		data, err := r.cipher.Open(rec.Sealed)
+		if err != nil {
+			return nil, fmt.Errorf("decrypting job result: %w", err)
+		}
+		job.Result = data
+	}
+	return &job, nil
+}
+
+// Cancel stops a queued or running job. The job is marked canceled once
+// its operation has returned.
+func (r *Runner) Cancel(id string) (*Job, error) {
+	if _, err := r.get(id); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	if cancel, ok := r.cancels[id]; ok {
+		cancel()
+	}
+	r.mu.Unlock()
+	return r.Get(id)
+}
+
+// Shutdown stops accepting jobs and waits for the running ones until ctx
+// is done. Jobs still running then are stopped and left for Resume.
+func (r *Runner) Shutdown(ctx context.Context) {
+	r.mu.Lock()
+	r.closing = true
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		r.stopJobs()
+		<-done
+	}
+}
+
+// Purge deletes the finished jobs whose retention has passed and returns
+// how many were deleted. Queued and running jobs are never purged.
+func (r *Runner) Purge() (int, error) {
+	if r.retention <= 0 {
+		return 0, nil
+	}
+	var expired [][]byte
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		cutoff := time.Now().Add(-r.retention)
+		err := bucket.ForEach(func(id, data []byte) error {
+			rec := &record{}
+			if err := json.Unmarshal(data, rec); err != nil {
+				return nil
+			}
+			if rec.Status.finished() && rec.FinishedAt != nil && rec.FinishedAt.Before(cutoff) {
+				expired = append(expired, append([]byte(nil), id...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range expired {
+			if err := bucket.Delete(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return len(expired), err
+}
+
+// RunPurger purges expired jobs every interval until ctx is done.
+func (r *Runner) RunPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if purged, err := r.Purge(); err != nil {
+				log.Error().Err(err).Msg("purging jobs failed")
+			} else if purged > 0 {
+				log.Debug().Int("purged", purged).Msg("purged finished jobs")
+			}
+		}
+	}
+}
+
+func (r *Runner) get(id string) (*record, error) {
+	rec := &record{}
+	err := r.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobsBucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (r *Runner) put(rec *record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(rec.ID), data)
+	})
+}
+
+func (r *Runner) update(id string, fn func(rec *record)) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		rec := &record{}
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(data, rec); err != nil {
+			return err
+		}
+		fn(rec)
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), data)
+	})
+}
+
+func newID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,83 @@
+package locks
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Advisory locks are session-level: each lock keeps the connection that
+// took it until it is released, and is released by the server if that
+// connection dies.
+
+// Postgres holds locks with pg_try_advisory_lock.
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+func (p *Postgres) TryLock(ctx context.Context, key string) (func(), error) {
+	id := advisoryID(key)
+	return tryAdvisoryLock(ctx, p.db,
+		"SELECT pg_try_advisory_lock($1)", id,
+		"SELECT pg_advisory_unlock($1)", id)
+}
+
+// MySQL holds locks with GET_LOCK.
+type MySQL struct {
+	db *sql.DB
+}
+
+func NewMySQL(db *sql.DB) *MySQL {
+	return &MySQL{db: db}
+}
+
+func (m *MySQL) TryLock(ctx context.Context, key string) (func(), error) {
+	// Lock names are limited to 64 characters.
+	sum := sha1.Sum([]byte(key))
+	name := "go-db-manager:" + hex.EncodeToString(sum[:])
+	return tryAdvisoryLock(ctx, m.db,
+		"SELECT COALESCE(GET_LOCK(?, 0), 0) = 1", name,
+		"SELECT RELEASE_LOCK(?)", name)
+}
+
+func tryAdvisoryLock(ctx context.Context, db *sql.DB, lockQuery string, lockArg interface{}, unlockQuery string, unlockArg interface{}) (func(), error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, lockQuery, lockArg).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !acquired {
+		conn.Close()
+		return nil, ErrLocked
+	}
+	return func() {
+		// The operation's context may be done by now; the lock must
+		// still be released.
+		if _, err := conn.ExecContext(context.Background(), unlockQuery, unlockArg); err != nil {
+			log.Error().Err(err).Msg("releasing advisory lock failed")
+			// Closing the session is the only other way to release it,
+			// so the connection must not go back to the pool.
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, nil
+}
+
+// advisoryID maps a key to the bigint Postgres advisory locks take.
+func advisoryID(key string) int64 {
+	sum := sha1.Sum([]byte(key))
+	return int64(binary.BigEndian.Uint64(sum[:8]))
+}
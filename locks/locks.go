@@ -0,0 +1,95 @@
+// Package locks serializes mutating operations on the same database.
+// Locks are keyed by "engine/target/database". The local backend covers a
+// single manager process; the advisory backends hold the locks in a
+// Postgres or MySQL server so that several replicas exclude each other.
+package locks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrLocked is returned when a lock is held by another operation.
+var ErrLocked = errors.New("locked by another operation")
+
+// Locker takes exclusive locks without waiting.
+type Locker interface {
+	// TryLock takes the lock on key, or returns ErrLocked if it is held.
+	// The returned function releases it.
+	TryLock(ctx context.Context, key string) (unlock func(), err error)
+}
+
+// Key is the lock key of a database.
+func Key(engine, target, database string) string {
+	return engine + "/" + target + "/" + database
+}
+
+// retryInterval is how often Acquire retries a held lock.
+const retryInterval = 100 * time.Millisecond
+
+// Acquire takes the locks on all keys, in sorted order so that concurrent
+// callers cannot deadlock. While a lock is held elsewhere it retries for
+// up to wait; with no wait, contention fails at once with ErrLocked.
+func Acquire(ctx context.Context, locker Locker, keys []string, wait time.Duration) (unlock func(), err error) {
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+
+	var unlocks []func()
+	release := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+	deadline := time.Now().Add(wait)
+	for i, key := range keys {
+		if i > 0 && key == keys[i-1] {
+			continue
+		}
+		for {
+			unlockKey, err := locker.TryLock(ctx, key)
+			if err == nil {
+				unlocks = append(unlocks, unlockKey)
+				break
+			}
+			if !errors.Is(err, ErrLocked) || time.Now().After(deadline) {
+				release()
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			select {
+			case <-ctx.Done():
+				release()
+				return nil, ctx.Err()
+			case <-time.After(retryInterval):
+			}
+		}
+	}
+	return release, nil
+}
+
+// Local holds locks in memory, for a single manager process.
+type Local struct {
+	mu   sync.Mutex
+	held map[string]bool
+}
+
+func NewLocal() *Local {
+	return &Local{held: make(map[string]bool)}
+}
+
+func (l *Local) TryLock(ctx context.Context, key string) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held[key] {
+		return nil, ErrLocked
+	}
+	l.held[key] = true
+	return func() {
+		l.mu.Lock()
+		delete(l.held, key)
+		l.mu.Unlock()
+	}, nil
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"database/sql"
 	"fmt"
 	"net/http"
 	"os"
@@ -9,54 +11,19 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/bonheur15/go-db-manager/catalog"
+	"github.com/bonheur15/go-db-manager/database"
 	"github.com/bonheur15/go-db-manager/handlers"
+	"github.com/bonheur15/go-db-manager/idempotency"
+	"github.com/bonheur15/go-db-manager/jobs"
+	"github.com/bonheur15/go-db-manager/locks"
+	"github.com/bonheur15/go-db-manager/manager"
 	"github.com/bonheur15/go-db-manager/utils"
 	"github.com/gin-gonic/gin"
-	"github.com/gofor-little/env"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/time/rate"
 )
 
-type Config struct {
-	MongoURI           string
-	MySQLDbHost        string
-	MySQLDbUser        string
-	MySQLDbPassword    string
-	MySQLDbPort        string
-	PostgresDbHost     string
-	PostgresDbUser     string
-	PostgresDbPassword string
-	PostgresDbPort     string
-	APIKey             string
-	Sslmode            string
-}
-
-func LoadConfig() (*Config, error) {
-	if err := env.Load(".env"); err != nil {
-		return nil, fmt.Errorf("error loading .env file: %w", err)
-	}
-
-	config := &Config{
-		MongoURI:           os.Getenv("MONGO_URI"),
-		MySQLDbHost:        os.Getenv("MYSQL_DB_HOST"),
-		MySQLDbUser:        os.Getenv("MYSQL_DB_USER"),
-		MySQLDbPassword:    os.Getenv("MYSQL_DB_PASSWORD"),
-		MySQLDbPort:        os.Getenv("MYSQL_DB_PORT"),
-		PostgresDbHost:     os.Getenv("POSTGRES_DB_HOST"),
-		PostgresDbUser:     os.Getenv("POSTGRES_DB_USER"),
-		PostgresDbPassword: os.Getenv("POSTGRES_DB_PASSWORD"),
-		PostgresDbPort:     os.Getenv("POSTGRES_DB_PORT"),
-		APIKey:             os.Getenv("API_KEY"),
-		Sslmode:            os.Getenv("SSL_MODE"),
-	}
-
-	if config.APIKey == "" {
-		return nil, fmt.Errorf("API_KEY environment variable not set")
-	}
-
-	return config, nil
-}
-
 func AuthMiddleware(apiKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if c.GetHeader("X-API-KEY") != apiKey {
@@ -67,6 +34,43 @@ func AuthMiddleware(apiKey string) gin.HandlerFunc {
 	}
 }
 
+// newLocker builds the lock backend. The advisory backends open their own
+// connections to the lock target, since each held lock pins one. They get
+// the target's pool limits, so that at most MaxOpenConns locks are held at
+// once and idle connections are recycled like the engine's.
+func newLocker(config *Config, registry *database.Registry) (locks.Locker, error) {
+	if config.LockBackend != "postgres" && config.LockBackend != "mysql" {
+		return locks.NewLocal(), nil
+	}
+	engine, err := registry.Get(config.LockTarget)
+	if err != nil {
+		return nil, err
+	}
+	if engine.Name() != config.LockBackend {
+		return nil, fmt.Errorf("lock target %s is not a %s target", config.LockTarget, config.LockBackend)
+	}
+
+	target := registry.Config(engine)
+	var db *sql.DB
+	if config.LockBackend == "postgres" {
+		db, err = database.ConnectToPostgresDB(target)
+	} else {
+		db, err = database.ConnectToMySQLDB(target)
+	}
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(target.Pool.MaxOpenConns)
+	db.SetMaxIdleConns(target.Pool.MaxIdleConns)
+	db.SetConnMaxLifetime(target.Pool.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(target.Pool.ConnMaxIdleTime)
+
+	if config.LockBackend == "postgres" {
+		return locks.NewPostgres(db), nil
+	}
+	return locks.NewMySQL(db), nil
+}
+
 func main() {
 	utils.InitLogger()
 	log.Info().Msg("Started Program")
@@ -76,40 +80,94 @@ func main() {
 		log.Fatal().Err(err).Msg("Failed to load config")
 	}
 
+	store, err := catalog.Open(config.CatalogPath)
+	if err != nil {
+		log.Fatal().Err(err).Str("path", config.CatalogPath).Msg("Failed to open catalog")
+	}
+	defer store.Close()
+
+	cipher, err := utils.NewCipher(config.EncryptionKey)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up encryption")
+	}
+	idempotencyKeys, err := idempotency.New(store.DB(), cipher, config.IdempotencyWindow)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up idempotency keys")
+	}
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	defer stopPurge()
+	go idempotencyKeys.Run(purgeCtx, time.Hour)
+
 	rateLimiter := handlers.NewIPRateLimiter(rate.Limit(10), 20)
 
 	routes := gin.Default()
 	routes.Use(rateLimiter.RateLimit())
 	routes.Use(AuthMiddleware(config.APIKey))
+	routes.Use(handlers.Idempotency(idempotencyKeys))
 
 	routes.GET("/server-info", handlers.GetServerInfoHandler)
 
-	mysqlRoutes := routes.Group("/mysql")
-	{
-		mysqlRoutes.POST("/databases", handlers.CreateMySQLHandler(config.MySQLDbHost, config.MySQLDbUser, config.MySQLDbPassword, config.MySQLDbPort))
-		mysqlRoutes.PATCH("/databases/:dbName/credentials", handlers.MySQLResetCredentialsHandler(config.MySQLDbHost, config.MySQLDbUser, config.MySQLDbPassword, config.MySQLDbPort))
-		mysqlRoutes.PATCH("/databases/:dbName", handlers.MySQLRenameDatabaseHandler(config.MySQLDbHost, config.MySQLDbUser, config.MySQLDbPassword, config.MySQLDbPort))
-		mysqlRoutes.DELETE("/databases/:dbName", handlers.MySQLDeleteDatabaseHandler(config.MySQLDbHost, config.MySQLDbUser, config.MySQLDbPassword, config.MySQLDbPort))
-		mysqlRoutes.GET("/databases/:dbName/stats", handlers.MySQLViewDatabaseStatsHandler(config.MySQLDbHost, config.MySQLDbUser, config.MySQLDbPassword, config.MySQLDbPort))
+	registry := database.NewRegistry()
+	for _, target := range config.Targets {
+		engine, err := database.NewEngine(target)
+		if err != nil {
+			log.Fatal().Err(err).Str("target", target.Ref()).Msg("Failed to configure target")
+		}
+		if err := registry.Register(engine, target); err != nil {
+			log.Fatal().Err(err).Msg("Failed to register target")
+		}
 	}
 
-	mongoRoutes := routes.Group("/mongo")
-	{
-		mongoRoutes.POST("/databases", handlers.CreateMongoHandler(config.MongoURI))
-		mongoRoutes.PATCH("/databases/:dbName/credentials", handlers.MongoResetCredentialsHandler(config.MongoURI))
-		mongoRoutes.PATCH("/databases/:dbName", handlers.MongoRenameDatabaseHandler(config.MongoURI))
-		mongoRoutes.DELETE("/databases/:dbName", handlers.MongoDeleteDatabaseHandler(config.MongoURI))
-		mongoRoutes.GET("/databases/:dbName/stats", handlers.MongoViewDatabaseStatsHandler(config.MongoURI))
+	registry.Connect(context.Background())
+	defer registry.Close()
+
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	defer stopMonitor()
+	if config.HealthCheckPeriod > 0 {
+		go registry.Monitor(monitorCtx, config.HealthCheckPeriod)
+	}
+
+	locker, err := newLocker(config, registry)
+	if err != nil {
+		log.Fatal().Err(err).Str("backend", config.LockBackend).Msg("Failed to set up locks")
+	}
+
+	dbManager := manager.New(registry, manager.Config{
+		Placement:        config.Placement,
+		Catalog:          store,
+		Locker:           locker,
+		LockWait:         config.LockWait,
+		RecycleRetention: config.RecycleRetention,
+		RotationGrace:    config.RotationGrace,
+		Names:            config.Names,
+	})
+	if config.RecycleRetention > 0 {
+		go dbManager.RunPurger(purgeCtx, min(config.RecycleRetention, time.Hour))
+	}
+	go dbManager.RunRotations(purgeCtx, time.Minute)
+	runner, err := jobs.New(store.DB(), cipher, config.JobConcurrency, config.JobRetention)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up jobs")
+	}
+	if config.JobRetention > 0 {
+		go runner.RunPurger(purgeCtx, min(config.JobRetention, time.Hour))
+	}
+	handlers.RegisterJobKinds(runner, dbManager)
+	if err := runner.Resume(); err != nil {
+		log.Error().Err(err).Msg("Failed to resume jobs")
 	}
 
-	postgresRoutes := routes.Group("/postgres")
-	{
-		postgresRoutes.POST("/databases", handlers.CreatePostgresHandler(config.PostgresDbHost, config.PostgresDbUser, config.PostgresDbPassword, config.PostgresDbPort, config.Sslmode))
-		postgresRoutes.PATCH("/databases/:dbName/credentials", handlers.PostgresResetCredentialsHandler(config.PostgresDbHost, config.PostgresDbUser, config.PostgresDbPassword, config.PostgresDbPort, config.Sslmode))
-		postgresRoutes.PATCH("/databases/:dbName", handlers.PostgresRenameDatabaseHandler(config.PostgresDbHost, config.PostgresDbUser, config.PostgresDbPassword, config.PostgresDbPort, config.Sslmode))
-		postgresRoutes.DELETE("/databases/:dbName", handlers.PostgresDeleteDatabaseHandler(config.PostgresDbHost, config.PostgresDbUser, config.PostgresDbPassword, config.PostgresDbPort, config.Sslmode))
-		postgresRoutes.GET("/databases/:dbName/stats", handlers.PostgresViewDatabaseStatsHandler(config.PostgresDbHost, config.PostgresDbUser, config.PostgresDbPassword, config.PostgresDbPort, config.Sslmode))
-		postgresRoutes.GET("/databases/queries", handlers.PostgresGetTotalQueriesHandler(config.PostgresDbHost, config.PostgresDbUser, config.PostgresDbPassword, config.PostgresDbPort, config.Sslmode))
+	// Confirmation tokens are signed with a key derived from the
+	// encryption key, so that replicas accept each other's tokens.
+	confirmationKey := sha256.Sum256(append([]byte("confirmation-tokens:"), config.EncryptionKey...))
+	confirmations := handlers.NewConfirmations(confirmationKey[:], config.ConfirmationTTL, config.ConfirmEngines)
+
+	v1 := routes.Group("/v1")
+	v1.GET("/server-info", handlers.GetServerInfoHandler)
+	handlers.RegisterJobRoutes(v1, runner)
+	for _, engineName := range registry.EngineNames() {
+		handlers.RegisterV1Routes(v1.Group("/"+engineName), dbManager, runner, confirmations, engineName)
+		handlers.RegisterEngineRoutes(routes.Group("/"+engineName), dbManager, confirmations, engineName)
 	}
 
 	srv := &http.Server{
@@ -135,6 +193,9 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal().Err(err).Msg("Server forced to shutdown:")
 	}
+	// Jobs still running when the timeout expires are resumed or marked
+	// failed on the next start.
+	runner.Shutdown(ctx)
 
 	log.Info().Msg("Server exiting")
 }
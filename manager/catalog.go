@@ -0,0 +1,99 @@
+package manager
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bonheur15/go-db-manager/catalog"
+	"github.com/bonheur15/go-db-manager/database"
+	"github.com/rs/zerolog/log"
+)
+
+// knownUsers returns the users the catalog recorded for a database, or nil
+// when there is no catalog or the database was not created through it.
+func (m *Manager) knownUsers(engine database.Engine, dbName string) []string {
+	if m.config.Catalog == nil {
+		return nil
+	}
+	record, err := m.config.Catalog.Get(engine.Name(), engine.Target(), dbName)
+	if err != nil {
+		if !errors.Is(err, catalog.ErrNotFound) {
+			logCatalogError(err, engine, dbName, "get")
+		}
+		return nil
+	}
+	return record.Users
+}
+
+// catalogRecord returns the catalog record of a database, or nil when
+// there is no catalog or the database is not in it. Other catalog errors
+// are returned as internal errors, so that checks relying on the record,
+// such as deletion protection, fail closed.
+func (m *Manager) catalogRecord(engine database.Engine, dbName, operation string) (*catalog.Record, error) {
+	if m.config.Catalog == nil {
+		return nil, nil
+	}
+	record, err := m.config.Catalog.Get(engine.Name(), engine.Target(), dbName)
+	if errors.Is(err, catalog.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &database.OpError{Action: engine.Name() + "-" + operation + "-catalog", Code: database.CodeInternal, Err: err}
+	}
+	return record, nil
+}
+
+// record applies fn to the catalog record of a database and stamps the
+// operation. The database operation has already succeeded at this point,
+// so callers usually ignore the error, which is logged here.
+func (m *Manager) record(engine database.Engine, dbName, operation string, fn func(r *catalog.Record)) error {
+	if m.config.Catalog == nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	err := m.config.Catalog.Update(engine.Name(), engine.Target(), dbName, func(r *catalog.Record) error {
+		if r.CreatedAt.IsZero() {
+			r.CreatedAt = now
+		}
+		if r.Status == "" {
+			r.Status = catalog.StatusActive
+		}
+		fn(r)
+		r.LastOperation = operation
+		r.UpdatedAt = now
+		return nil
+	})
+	if err != nil {
+		logCatalogError(err, engine, dbName, operation)
+	}
+	return err
+}
+
+func (m *Manager) recordRename(engine database.Engine, oldName, newName string) {
+	if m.config.Catalog == nil {
+		return
+	}
+	now := time.Now().UTC()
+	err := m.config.Catalog.Rename(engine.Name(), engine.Target(), oldName, newName, func(r *catalog.Record) {
+		if r.CreatedAt.IsZero() {
+			r.CreatedAt = now
+		}
+		if r.Status == "" {
+			r.Status = catalog.StatusActive
+		}
+		r.LastOperation = "rename"
+		r.UpdatedAt = now
+	})
+	if err != nil {
+		logCatalogError(err, engine, oldName, "rename")
+	}
+}
+
+func logCatalogError(err error, engine database.Engine, dbName, operation string) {
+	log.Error().Err(err).
+		Str("engine", engine.Name()).
+		Str("target", engine.Target()).
+		Str("database", dbName).
+		Str("operation", operation).
+		Msg("catalog update failed")
+}
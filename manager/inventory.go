@@ -0,0 +1,180 @@
+package manager
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bonheur15/go-db-manager/catalog"
+	"github.com/bonheur15/go-db-manager/database"
+)
+
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// ListOptions filters and pages ListDatabases. Owner and Labels match the
+// catalog, so they only select databases the manager created.
+type ListOptions struct {
+	Prefix string
+	Owner  string
+	Labels map[string]string
+	// Limit defaults to DefaultListLimit.
+	Limit  int `validate:"min=0,max=1000"`
+	Offset int `validate:"min=0"`
+	// Recycled lists the recycle bin instead of the live databases.
+	Recycled bool
+}
+
+// DatabaseSummary is one entry of the inventory. Managed is false for
+// databases that exist on the server but are not in the catalog.
+type DatabaseSummary struct {
+	Name      string            `json:"name"`
+	Engine    string            `json:"engine"`
+	Target    string            `json:"target"`
+	Managed   bool              `json:"managed"`
+	Owner     string            `json:"owner,omitempty"`
+	Users     []string          `json:"users,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	CreatedAt *time.Time        `json:"created_at,omitempty"`
+	// DeletionProtection is only set for databases in the catalog.
+	DeletionProtection bool `json:"deletion_protection"`
+	// OriginalName and PurgeAt are set for databases in the recycle bin.
+	OriginalName string     `json:"original_name,omitempty"`
+	PurgeAt      *time.Time `json:"purge_at,omitempty"`
+}
+
+type DatabaseList struct {
+	Databases []DatabaseSummary `json:"databases"`
+	// Total is the number of databases matching the filters, before
+	// paging.
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// ListDatabases lists the databases on the target named by ref, merged
+// with their catalog records, sorted by name.
+func (m *Manager) ListDatabases(ctx context.Context, ref string, opts ListOptions) (*DatabaseList, error) {
+	engine, err := m.engine(ref, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Limit == 0 {
+		opts.Limit = DefaultListLimit
+	}
+
+	names, err := engine.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	records := m.catalogRecords(engine, catalog.StatusActive)
+	recycled := m.catalogRecords(engine, catalog.StatusRecycled)
+	filterCatalog := opts.Owner != "" || len(opts.Labels) > 0
+	filter := catalog.Filter{Owner: opts.Owner, Labels: opts.Labels}
+
+	sort.Strings(names)
+	list := &DatabaseList{Databases: []DatabaseSummary{}, Limit: opts.Limit, Offset: opts.Offset}
+	for _, name := range names {
+		if !strings.HasPrefix(name, opts.Prefix) || m.checkName(engine, name) != nil {
+			continue
+		}
+		recycledRecord, inBin := recycled[name]
+		if inBin != opts.Recycled {
+			continue
+		}
+		record, managed := records[name]
+		if inBin {
+			record, managed = recycledRecord, true
+		}
+		if filterCatalog && (!managed || !filter.Matches(record)) {
+			continue
+		}
+
+		list.Total++
+		if list.Total <= opts.Offset || len(list.Databases) >= opts.Limit {
+			continue
+		}
+		summary := DatabaseSummary{Name: name, Engine: engine.Name(), Target: engine.Target(), Managed: managed}
+		if managed {
+			createdAt := record.CreatedAt
+			summary.Owner = record.Owner
+			summary.Users = record.Users
+			summary.Labels = record.Labels
+			summary.DeletionProtection = record.DeletionProtection
+			summary.CreatedAt = &createdAt
+			summary.OriginalName = record.OriginalName
+			summary.PurgeAt = record.PurgeAt
+		}
+		list.Databases = append(list.Databases, summary)
+	}
+	return list, nil
+}
+
+// DatabaseDescription is the server's view of a database completed with
+// its catalog record.
+type DatabaseDescription struct {
+	database.DatabaseInfo
+	Engine  string            `json:"engine"`
+	Target  string            `json:"target"`
+	Managed bool              `json:"managed"`
+	Owner   string            `json:"owner,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	// DeletionProtection is only set for databases in the catalog.
+	DeletionProtection bool `json:"deletion_protection"`
+	// ManagedUsers are the users the manager created for the database.
+	ManagedUsers []string `json:"managed_users,omitempty"`
+}
+
+func (m *Manager) DescribeDatabase(ctx context.Context, ref string, opts database.DatabaseOptions) (*DatabaseDescription, error) {
+	engine, err := m.engine(ref, opts)
+	if err != nil {
+		return nil, err
+	}
+	info, err := engine.Describe(ctx, opts.DatabaseName)
+	if err != nil {
+		return nil, err
+	}
+	if info.Users == nil {
+		info.Users = []string{}
+	}
+
+	description := &DatabaseDescription{DatabaseInfo: *info, Engine: engine.Name(), Target: engine.Target()}
+	if record, ok := m.catalogRecords(engine, catalog.StatusActive)[opts.DatabaseName]; ok {
+		description.Managed = true
+		description.Owner = record.Owner
+		description.Labels = record.Labels
+		description.DeletionProtection = record.DeletionProtection
+		description.ManagedUsers = record.Users
+		if description.CreatedAt == nil {
+			createdAt := record.CreatedAt
+			description.CreatedAt = &createdAt
+		}
+	}
+	return description, nil
+}
+
+// catalogRecords returns the catalog records of engine's target with
+// status by database name. Without a catalog, or when it cannot be read, the map is
+// empty and databases are reported as unmanaged.
+func (m *Manager) catalogRecords(engine database.Engine, status catalog.Status) map[string]*catalog.Record {
+	records := make(map[string]*catalog.Record)
+	if m.config.Catalog == nil {
+		return records
+	}
+	list, err := m.config.Catalog.List(catalog.Filter{
+		Engine: engine.Name(),
+		Target: engine.Target(),
+		Status: status,
+	})
+	if err != nil {
+		logCatalogError(err, engine, "", "list")
+		return records
+	}
+	for i := range list {
+		records[list[i].Name] = &list[i]
+	}
+	return records
+}
@@ -0,0 +1,29 @@
+package manager
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bonheur15/go-db-manager/database"
+	"github.com/bonheur15/go-db-manager/locks"
+)
+
+// lock takes the operation locks on dbNames of engine's target. Mutating
+// operations hold them until they return, so that two of them never run
+// on the same database at once.
+func (m *Manager) lock(ctx context.Context, engine database.Engine, dbNames ...string) (func(), error) {
+	keys := make([]string, len(dbNames))
+	for i, dbName := range dbNames {
+		keys[i] = locks.Key(engine.Name(), engine.Target(), dbName)
+	}
+	unlock, err := locks.Acquire(ctx, m.locker, keys, m.config.LockWait)
+	if err == nil {
+		return unlock, nil
+	}
+
+	code := database.CodeUpstreamUnavailable
+	if errors.Is(err, locks.ErrLocked) {
+		code = database.CodeConflict
+	}
+	return nil, &database.OpError{Action: engine.Name() + "-lock", Code: code, Err: err}
+}
@@ -0,0 +1,302 @@
+// Package manager is the Go API for provisioning databases. It is what the
+// HTTP handlers call, and it can be imported directly by programs that want
+// to manage databases without running the server.
+package manager
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bonheur15/go-db-manager/catalog"
+	"github.com/bonheur15/go-db-manager/database"
+	"github.com/bonheur15/go-db-manager/locks"
+)
+
+// Manager runs database operations against the engines in its registry.
+type Manager struct {
+	registry *database.Registry
+	config   Config
+	placer   placer
+	locker   locks.Locker
+}
+
+// Config holds the manager-wide settings.
+type Config struct {
+	// Placement chooses the target for creates that name only the engine.
+	Placement PlacementPolicy
+	// Catalog records the databases the manager creates. It is optional;
+	// without it the manager only knows what the servers report.
+	Catalog *catalog.Store
+	// Locker serializes mutating operations on the same database. It
+	// defaults to in-process locks; replicas sharing servers need an
+	// advisory-lock backend.
+	Locker locks.Locker
+	// LockWait is how long an operation waits for a database locked by
+	// another one before failing with a conflict.
+	LockWait time.Duration
+	// Names restricts the databases the manager operates on.
+	Names NamePolicy
+	// RecycleRetention is how long deleted databases are kept in the
+	// recycle bin before PurgeRecycled drops them. Zero, or a nil Catalog,
+	// makes deletes permanent.
+	RecycleRetention time.Duration
+	// RotationGrace is how long the old credential of a rotation keeps
+	// working when the request does not say. Zero waits for
+	// ConfirmRotation.
+	RotationGrace time.Duration
+}
+
+func New(registry *database.Registry, config Config) *Manager {
+	locker := config.Locker
+	if locker == nil {
+		locker = locks.NewLocal()
+	}
+	return &Manager{registry: registry, config: config, locker: locker}
+}
+
+func (m *Manager) Registry() *database.Registry {
+	return m.registry
+}
+
+// engine resolves ref and validates opts, if given, against its validate
+// tags and the name policy. ref is either an engine name, which selects
+// the engine's default target, or an "engine/target" reference such as
+// "mysql/eu-1".
+func (m *Manager) engine(ref string, opts interface{}) (database.Engine, error) {
+	engine, err := m.registry.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+	if opts != nil {
+		if err := database.ValidateFor(engine.Name(), opts); err != nil {
+			return nil, &database.OpError{Action: engine.Name() + "-validation", Code: database.CodeInvalidArgument, Err: err}
+		}
+		if err := m.checkNames(engine, opts); err != nil {
+			return nil, err
+		}
+	}
+	return engine, nil
+}
+
+// Target describes a configured server of an engine.
+type Target struct {
+	Engine  string `json:"engine"`
+	Name    string `json:"name"`
+	Default bool   `json:"default"`
+}
+
+func (m *Manager) Targets(engineName string) []Target {
+	defaultTarget := m.registry.DefaultTarget(engineName)
+	var targets []Target
+	for _, engine := range m.registry.Targets(engineName) {
+		targets = append(targets, Target{
+			Engine:  engine.Name(),
+			Name:    engine.Target(),
+			Default: engine.Target() == defaultTarget,
+		})
+	}
+	return targets
+}
+
+// CreateDatabase creates a database on the target named by ref. When ref
+// names only the engine, the target is chosen by the placement policy of
+// opts, falling back to the manager's policy.
+func (m *Manager) CreateDatabase(ctx context.Context, ref string, opts database.CreateOptions) (*database.Credentials, error) {
+	engineName, target := database.ParseRef(ref)
+	if err := database.ValidateFor(engineName, opts); err != nil {
+		return nil, &database.OpError{Action: engineName + "-validation", Code: database.CodeInvalidArgument, Err: err}
+	}
+	name, err := m.config.Names.expand(opts)
+	if err == nil {
+		err = database.ValidateName(engineName, "database_name", name)
+	}
+	if err != nil {
+		return nil, &database.OpError{Action: engineName + "-validation", Code: database.CodeInvalidArgument, Err: err}
+	}
+	opts.DatabaseName = name
+
+	var engine database.Engine
+	if target == "" {
+		policy := m.config.Placement
+		if opts.Placement != "" {
+			policy = PlacementPolicy(opts.Placement)
+		}
+		engine, err = m.place(ctx, engineName, policy)
+	} else {
+		engine, err = m.registry.Get(ref)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkNames(engine, opts); err != nil {
+		return nil, err
+	}
+
+	unlock, err := m.lock(ctx, engine, opts.DatabaseName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	creds, err := engine.Create(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	creds.Target = engine.Target()
+
+	m.record(engine, opts.DatabaseName, "create", func(r *catalog.Record) {
+		r.Owner = opts.Owner
+		r.Labels = opts.Labels
+		r.DeletionProtection = opts.DeletionProtection
+		r.Users = []string{creds.Username}
+		r.Rotations = nil
+		r.Status = catalog.StatusActive
+		r.DeletedAt = nil
+	})
+	return creds, nil
+}
+
+func (m *Manager) RenameDatabase(ctx context.Context, ref string, opts database.RenameOptions) (*database.RenameResult, error) {
+	engine, err := m.engine(ref, opts)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := m.lock(ctx, engine, opts.OldDatabaseName, opts.NewDatabaseName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err := m.checkProtection(engine, opts.OldDatabaseName, "rename"); err != nil {
+		return nil, err
+	}
+	result, err := engine.Rename(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	m.recordRename(engine, opts.OldDatabaseName, opts.NewDatabaseName)
+	return result, nil
+}
+
+// DeleteDatabase moves a database to the recycle bin, from which
+// RestoreDatabase can bring it back until it is purged. Databases are
+// dropped right away when opts.Permanent is set, when the manager has no
+// catalog or recycle retention, and when they are already in the bin.
+func (m *Manager) DeleteDatabase(ctx context.Context, ref string, opts database.DeleteOptions) (*DeleteResult, error) {
+	engine, err := m.engine(ref, opts)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := m.lock(ctx, engine, opts.DatabaseName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	record, err := m.catalogRecord(engine, opts.DatabaseName, "delete")
+	if err != nil {
+		return nil, err
+	}
+	if record != nil && record.DeletionProtection {
+		return nil, protectedError(engine, opts.DatabaseName, "delete")
+	}
+	if opts.Users == nil && record != nil {
+		opts.Users = record.Users
+	}
+	recycled := record != nil && record.Status == catalog.StatusRecycled
+	if !opts.Permanent && !recycled && m.config.Catalog != nil && m.config.RecycleRetention > 0 {
+		return m.recycle(ctx, engine, opts)
+	}
+
+	if err := engine.Delete(ctx, opts); err != nil {
+		return nil, err
+	}
+
+	m.record(engine, opts.DatabaseName, "delete", func(r *catalog.Record) {
+		now := time.Now().UTC()
+		r.Status = catalog.StatusDeleted
+		r.DeletedAt = &now
+		r.PurgeAt = nil
+	})
+	return &DeleteResult{DatabaseName: opts.DatabaseName}, nil
+}
+
+func (m *Manager) DatabaseStats(ctx context.Context, ref string, opts database.DatabaseOptions) (*database.DatabaseStats, error) {
+	engine, err := m.engine(ref, opts)
+	if err != nil {
+		return nil, err
+	}
+	return engine.Stats(ctx, opts.DatabaseName)
+}
+
+// ResetCredentials replaces the credentials of a database. With
+// opts.GraceSeconds the old users are left in place and retired once the
+// grace period is over, like the old credential of a rotation; otherwise
+// they stop working right away.
+func (m *Manager) ResetCredentials(ctx context.Context, ref string, opts database.ResetCredentialsOptions) (*database.Credentials, error) {
+	engine, err := m.engine(ref, opts)
+	if err != nil {
+		return nil, err
+	}
+	graceful := opts.GraceSeconds != nil && *opts.GraceSeconds > 0
+	if graceful {
+		if _, err := m.rotator(engine, "reset-credentials"); err != nil {
+			return nil, err
+		}
+		opts.KeepUsers = true
+	}
+	unlock, err := m.lock(ctx, engine, opts.DatabaseName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if opts.Users == nil {
+		opts.Users = m.knownUsers(engine, opts.DatabaseName)
+	}
+	creds, err := engine.ResetCredentials(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if graceful {
+		if err := m.recordReplacement(ctx, engine, opts.DatabaseName, creds, time.Duration(*opts.GraceSeconds)*time.Second); err != nil {
+			return nil, err
+		}
+		return creds, nil
+	}
+
+	m.record(engine, opts.DatabaseName, "reset-credentials", func(r *catalog.Record) {
+		// MySQL and Postgres replace every user with a new one; MongoDB
+		// keeps the user and only changes its password.
+		for _, username := range r.Users {
+			if username == creds.Username {
+				return
+			}
+		}
+		r.Users = []string{creds.Username}
+		r.Rotations = nil
+	})
+	return creds, nil
+}
+
+func (m *Manager) Ping(ctx context.Context, ref string) error {
+	engine, err := m.engine(ref, nil)
+	if err != nil {
+		return err
+	}
+	return engine.Ping(ctx)
+}
+
+// TotalQueries reports per-user query counts for engines that support it.
+func (m *Manager) TotalQueries(ctx context.Context, ref string) ([]database.QueryActivity, error) {
+	engine, err := m.engine(ref, nil)
+	if err != nil {
+		return nil, err
+	}
+	reporter, ok := engine.(database.QueryActivityReporter)
+	if !ok {
+		return nil, &database.OpError{Action: engine.Name() + "-get-total-queries", Code: database.CodeInvalidArgument, Err: fmt.Errorf("engine %s does not report query activity", engine.Name())}
+	}
+	return reporter.TotalQueries(ctx)
+}
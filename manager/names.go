@@ -0,0 +1,116 @@
+package manager
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bonheur15/go-db-manager/database"
+)
+
+// NamePolicy restricts which databases the manager operates on, on top of
+// the system databases every engine protects. Patterns are exact names or
+// prefixes ending in "*", such as "app_*".
+type NamePolicy struct {
+	// Allow, when not empty, limits the manager to names matching one of
+	// its patterns.
+	Allow []string
+	// Deny lists names the manager refuses even when Allow matches them.
+	Deny []string
+	// Template, when set, builds the names of new databases from the
+	// requested name and other placeholders, e.g. "{tenant}_{env}_{name}".
+	// Placeholders are filled from the create request's labels, then from
+	// Vars.
+	Template string
+	Vars     map[string]string
+}
+
+var placeholderPattern = regexp.MustCompile(`\{([A-Za-z0-9_-]+)\}`)
+
+// expand returns the name of the database opts creates.
+func (p NamePolicy) expand(opts database.CreateOptions) (string, error) {
+	if p.Template == "" {
+		return opts.DatabaseName, nil
+	}
+	var missing []database.FieldError
+	name := placeholderPattern.ReplaceAllStringFunc(p.Template, func(placeholder string) string {
+		key := placeholder[1 : len(placeholder)-1]
+		if key == "name" {
+			return opts.DatabaseName
+		}
+		if value, ok := opts.Labels[key]; ok {
+			return value
+		}
+		if value, ok := p.Vars[key]; ok {
+			return value
+		}
+		missing = append(missing, database.FieldError{
+			Field:   "labels." + key,
+			Rule:    "template",
+			Message: fmt.Sprintf("is required by the naming template %s", p.Template),
+		})
+		return placeholder
+	})
+	if missing != nil {
+		return "", &database.ValidationError{Fields: missing}
+	}
+	return name, nil
+}
+
+func (p NamePolicy) allows(name string) bool {
+	for _, pattern := range p.Deny {
+		if matchName(pattern, name) {
+			return false
+		}
+	}
+	if len(p.Allow) == 0 {
+		return true
+	}
+	for _, pattern := range p.Allow {
+		if matchName(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchName(pattern, name string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(name, prefix)
+	}
+	return name == pattern
+}
+
+// namedOptions is implemented by the options of every operation on
+// existing or new databases.
+type namedOptions interface {
+	DatabaseNames() []string
+}
+
+// checkNames refuses operations on system databases and on names the
+// manager's name policy excludes.
+func (m *Manager) checkNames(engine database.Engine, opts interface{}) error {
+	named, ok := opts.(namedOptions)
+	if !ok {
+		return nil
+	}
+	for _, name := range named.DatabaseNames() {
+		if err := m.checkName(engine, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Manager) checkName(engine database.Engine, name string) error {
+	var err error
+	switch {
+	case engine.IsSystemDatabase(name):
+		err = fmt.Errorf("%s is a system database of %s", name, engine.Name())
+	case !m.config.Names.allows(name):
+		err = fmt.Errorf("database name %s is not allowed by the name policy", name)
+	default:
+		return nil
+	}
+	return &database.OpError{Action: engine.Name() + "-name-policy", Code: database.CodePermissionDenied, Err: err}
+}
@@ -0,0 +1,53 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bonheur15/go-db-manager/database"
+)
+
+func TestNamePolicyExpand(t *testing.T) {
+	policy := NamePolicy{
+		Template: "{tenant}_{env}_{name}",
+		Vars:     map[string]string{"env": "prod", "tenant": "default"},
+	}
+	tests := []struct {
+		name   string
+		policy NamePolicy
+		opts   database.CreateOptions
+		want   string
+	}{
+		{"no template", NamePolicy{}, database.CreateOptions{DatabaseName: "shop"}, "shop"},
+		{"vars", policy, database.CreateOptions{DatabaseName: "shop"}, "default_prod_shop"},
+		{"labels before vars", policy, database.CreateOptions{DatabaseName: "shop", Labels: map[string]string{"tenant": "acme"}}, "acme_prod_shop"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.policy.expand(test.opts)
+			if err != nil {
+				t.Fatalf("expand = %v", err)
+			}
+			if got != test.want {
+				t.Errorf("expand = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNamePolicyExpandMissing(t *testing.T) {
+	policy := NamePolicy{Template: "{tenant}_{region}_{name}"}
+	_, err := policy.expand(database.CreateOptions{DatabaseName: "shop"})
+
+	var validationErr *database.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expand = %v, want a validation error", err)
+	}
+	var fields []string
+	for _, field := range validationErr.Fields {
+		fields = append(fields, field.Field)
+	}
+	if len(fields) != 2 || fields[0] != "labels.tenant" || fields[1] != "labels.region" {
+		t.Errorf("fields = %v, want [labels.tenant labels.region]", fields)
+	}
+}
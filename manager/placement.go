@@ -0,0 +1,155 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/bonheur15/go-db-manager/database"
+	"github.com/bonheur15/go-db-manager/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// PlacementPolicy decides which target receives a new database when the
+// caller names only the engine.
+type PlacementPolicy string
+
+const (
+	// PlaceDefault always uses the engine's default target.
+	PlaceDefault            PlacementPolicy = ""
+	PlaceFewestDatabases    PlacementPolicy = "fewest-databases"
+	PlaceMostFreeDisk       PlacementPolicy = "most-free-disk"
+	PlaceLowestLoad         PlacementPolicy = "lowest-load"
+	PlaceWeightedRoundRobin PlacementPolicy = "weighted-round-robin"
+)
+
+func ParsePlacementPolicy(value string) (PlacementPolicy, error) {
+	switch policy := PlacementPolicy(value); policy {
+	case PlaceDefault, PlaceFewestDatabases, PlaceMostFreeDisk, PlaceLowestLoad, PlaceWeightedRoundRobin:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown placement policy %q", value)
+	}
+}
+
+// placer keeps the state weighted round-robin needs between requests.
+type placer struct {
+	mu      sync.Mutex
+	current map[string]int
+}
+
+// place picks a target of engineName according to policy.
+func (m *Manager) place(ctx context.Context, engineName string, policy PlacementPolicy) (database.Engine, error) {
+	if policy == PlaceDefault {
+		return m.registry.Get(engineName)
+	}
+
+	targets := m.registry.Targets(engineName)
+	if len(targets) == 0 {
+		return m.registry.Get(engineName)
+	}
+	if policy == PlaceWeightedRoundRobin {
+		return m.placer.next(targets, m.registry), nil
+	}
+
+	var best database.Engine
+	var bestScore float64
+	for _, engine := range targets {
+		score, err := m.placementScore(ctx, engine, policy)
+		if err != nil {
+			log.Warn().Err(err).Str("engine", engine.Name()).Str("target", engine.Target()).Msg("skipping target for placement")
+			continue
+		}
+		if best == nil || score < bestScore {
+			best, bestScore = engine, score
+		}
+	}
+	if best == nil {
+		return nil, &database.OpError{
+			Action: engineName + "-placement",
+			Code:   database.CodeUpstreamUnavailable,
+			Err:    errors.New("no target is available for placement"),
+		}
+	}
+	return best, nil
+}
+
+// placementScore rates a target for policy; the lowest score wins. Every
+// target is rated on the same metric, read from its server, so that local
+// and remote targets compare fairly.
+func (m *Manager) placementScore(ctx context.Context, engine database.Engine, policy PlacementPolicy) (float64, error) {
+	config := m.registry.Config(engine)
+	usage, err := engine.Usage(ctx)
+	if err != nil {
+		return 0, err
+	}
+	switch policy {
+	case PlaceFewestDatabases:
+		return float64(usage.DatabaseCount), nil
+	case PlaceMostFreeDisk:
+		free, err := freeBytes(config, usage)
+		if err != nil {
+			return 0, err
+		}
+		return -float64(free), nil
+	case PlaceLowestLoad:
+		if usage.MaxConnections <= 0 {
+			return float64(usage.Connections), nil
+		}
+		return float64(usage.Connections) / float64(usage.MaxConnections), nil
+	default:
+		return 0, fmt.Errorf("unknown placement policy %q", policy)
+	}
+}
+
+// freeBytes is how much more data a target can take: its capacity_bytes
+// minus what its databases use, or for a local target without one, the
+// free space of the filesystem at DataPath, which also accounts for
+// everything else stored there.
+func freeBytes(config database.TargetConfig, usage *database.ServerUsage) (int64, error) {
+	if config.CapacityBytes > 0 {
+		return config.CapacityBytes - usage.UsedBytes, nil
+	}
+	if !config.Local {
+		return 0, errors.New("capacity_bytes is not configured")
+	}
+	path := config.DataPath
+	if path == "" {
+		path = "/"
+	}
+	disk, err := utils.GetDiskUsage(path)
+	if err != nil {
+		return 0, err
+	}
+	return int64(disk.Free), nil
+}
+
+// next implements smooth weighted round-robin: every pick adds each
+// target's weight to its counter, and the highest counter wins and is
+// reduced by the total weight.
+func (p *placer) next(targets []database.Engine, registry *database.Registry) database.Engine {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.current == nil {
+		p.current = make(map[string]int)
+	}
+
+	var best database.Engine
+	total := 0
+	for _, engine := range targets {
+		weight := registry.Config(engine).Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		key := engine.Name() + "/" + engine.Target()
+		p.current[key] += weight
+		total += weight
+		if best == nil || p.current[key] > p.current[best.Name()+"/"+best.Target()] {
+			best = engine
+		}
+	}
+	p.current[best.Name()+"/"+best.Target()] -= total
+	return best
+}
@@ -0,0 +1,141 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bonheur15/go-db-manager/database"
+	"github.com/bonheur15/go-db-manager/utils"
+)
+
+// fakeEngine is an Engine that knows its name and target and reports a
+// fixed usage; placement tests call nothing else.
+type fakeEngine struct {
+	database.Engine
+	name, target string
+	usage        database.ServerUsage
+	usageErr     error
+}
+
+func (e *fakeEngine) Name() string   { return e.name }
+func (e *fakeEngine) Target() string { return e.target }
+
+func (e *fakeEngine) Usage(ctx context.Context) (*database.ServerUsage, error) {
+	if e.usageErr != nil {
+		return nil, e.usageErr
+	}
+	usage := e.usage
+	return &usage, nil
+}
+
+// placementManager registers engines with their configs in a new manager.
+func placementManager(t *testing.T, engines []*fakeEngine, configs []database.TargetConfig) *Manager {
+	t.Helper()
+	registry := database.NewRegistry()
+	for i, engine := range engines {
+		if err := registry.Register(engine, configs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return New(registry, Config{})
+}
+
+func TestPlacerNextWeighted(t *testing.T) {
+	engines := []*fakeEngine{{name: "mysql", target: "a"}, {name: "mysql", target: "b"}, {name: "mysql", target: "c"}}
+	m := placementManager(t, engines, []database.TargetConfig{{Weight: 5}, {Weight: 1}, {Weight: 1}})
+	targets := m.registry.Targets("mysql")
+
+	var picks []string
+	for range 14 {
+		picks = append(picks, m.placer.next(targets, m.registry).Target())
+	}
+	// Smooth weighted round-robin spreads b and c between the a's and
+	// repeats every total weight picks.
+	if got, want := strings.Join(picks, ""), "aabacaaaabacaa"; got != want {
+		t.Errorf("picks = %s, want %s", got, want)
+	}
+}
+
+func TestPlacerNextDefaultWeight(t *testing.T) {
+	engines := []*fakeEngine{{name: "postgres", target: "a"}, {name: "postgres", target: "b"}}
+	m := placementManager(t, engines, []database.TargetConfig{{}, {}})
+	targets := m.registry.Targets("postgres")
+
+	counts := make(map[string]int)
+	for range 10 {
+		counts[m.placer.next(targets, m.registry).Target()]++
+	}
+	if counts["a"] != 5 || counts["b"] != 5 {
+		t.Errorf("picks = %v, want 5 each for unweighted targets", counts)
+	}
+}
+
+func TestPlace(t *testing.T) {
+	engines := []*fakeEngine{
+		{name: "mysql", target: "a", usage: database.ServerUsage{DatabaseCount: 3, UsedBytes: 10, Connections: 90, MaxConnections: 100}},
+		{name: "mysql", target: "b", usage: database.ServerUsage{DatabaseCount: 1, UsedBytes: 80, Connections: 10, MaxConnections: 100}},
+		{name: "mysql", target: "c", usage: database.ServerUsage{DatabaseCount: 2, UsedBytes: 50, Connections: 40, MaxConnections: 400}},
+		{name: "mysql", target: "down", usageErr: errors.New("unreachable")},
+	}
+	configs := []database.TargetConfig{{CapacityBytes: 100}, {CapacityBytes: 100}, {CapacityBytes: 100}, {CapacityBytes: 1000}}
+	m := placementManager(t, engines, configs)
+
+	tests := []struct {
+		policy PlacementPolicy
+		want   string
+	}{
+		{PlaceDefault, "a"},
+		{PlaceFewestDatabases, "b"},
+		{PlaceMostFreeDisk, "a"},
+		{PlaceLowestLoad, "b"},
+	}
+	for _, test := range tests {
+		engine, err := m.place(context.Background(), "mysql", test.policy)
+		if err != nil {
+			t.Fatalf("place(%q) = %v", test.policy, err)
+		}
+		if engine.Target() != test.want {
+			t.Errorf("place(%q) = %s, want %s", test.policy, engine.Target(), test.want)
+		}
+	}
+}
+
+func TestPlaceNoTargetAvailable(t *testing.T) {
+	engines := []*fakeEngine{{name: "mongo", target: "down", usageErr: errors.New("unreachable")}}
+	m := placementManager(t, engines, []database.TargetConfig{{}})
+
+	_, err := m.place(context.Background(), "mongo", PlaceFewestDatabases)
+	if database.CodeOf(err) != database.CodeUpstreamUnavailable {
+		t.Errorf("place = %v, want upstream_unavailable", err)
+	}
+}
+
+func TestFreeBytes(t *testing.T) {
+	usage := &database.ServerUsage{UsedBytes: 300}
+
+	free, err := freeBytes(database.TargetConfig{CapacityBytes: 1000}, usage)
+	if err != nil || free != 700 {
+		t.Errorf("freeBytes with capacity = %d, %v, want 700", free, err)
+	}
+	if _, err := freeBytes(database.TargetConfig{}, usage); err == nil {
+		t.Error("freeBytes of a remote target without capacity_bytes succeeded")
+	}
+
+	// A local target reports what the filesystem has left, whatever its
+	// own databases use.
+	dir := t.TempDir()
+	disk, err := utils.GetDiskUsage(dir)
+	if err != nil {
+		t.Skip(err)
+	}
+	free, err = freeBytes(database.TargetConfig{Local: true, DataPath: dir}, &database.ServerUsage{UsedBytes: int64(disk.Total)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Other writers may change the free space in between.
+	if diff := free - int64(disk.Free); diff < -64<<20 || diff > 64<<20 {
+		t.Errorf("freeBytes of a local target = %d, want about the filesystem's %d free bytes", free, disk.Free)
+	}
+}
@@ -0,0 +1,73 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/bonheur15/go-db-manager/catalog"
+	"github.com/bonheur15/go-db-manager/database"
+)
+
+// DeletionProtectionOptions turns the deletion protection of a database on
+// or off.
+type DeletionProtectionOptions struct {
+	DatabaseName string `json:"database_name" validate:"required,dbname"`
+	Enabled      bool   `json:"enabled"`
+}
+
+// SetDeletionProtection records in the catalog whether deletes and renames
+// of a database are refused. Databases created outside the manager are
+// added to the catalog.
+func (m *Manager) SetDeletionProtection(ctx context.Context, ref string, opts DeletionProtectionOptions) error {
+	engine, err := m.engine(ref, opts)
+	if err != nil {
+		return err
+	}
+	if m.config.Catalog == nil {
+		return &database.OpError{
+			Action: engine.Name() + "-deletion-protection",
+			Code:   database.CodeInvalidArgument,
+			Err:    errors.New("deletion protection needs the catalog"),
+		}
+	}
+	unlock, err := m.lock(ctx, engine, opts.DatabaseName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := engine.Describe(ctx, opts.DatabaseName); err != nil {
+		return err
+	}
+	operation := "unprotect"
+	if opts.Enabled {
+		operation = "protect"
+	}
+	return m.record(engine, opts.DatabaseName, operation, func(r *catalog.Record) {
+		r.DeletionProtection = opts.Enabled
+	})
+}
+
+// checkProtection fails operation on a database whose deletion protection
+// is on, or whose catalog record cannot be read.
+func (m *Manager) checkProtection(engine database.Engine, dbName, operation string) error {
+	record, err := m.catalogRecord(engine, dbName, operation)
+	if err != nil {
+		return err
+	}
+	if record == nil || !record.DeletionProtection {
+		return nil
+	}
+	return protectedError(engine, dbName, operation)
+}
+
+func protectedError(engine database.Engine, dbName, operation string) error {
+	return &database.OpError{
+		Action: engine.Name() + "-" + operation + "-database",
+		Code:   database.CodeConflict,
+		Err:    fmt.Errorf("database %s has deletion protection enabled; disable it before the %s", dbName, operation),
+	}
+}
+
+func (o DeletionProtectionOptions) DatabaseNames() []string { return []string{o.DatabaseName} }
@@ -0,0 +1,63 @@
+package manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bonheur15/go-db-manager/catalog"
+	"github.com/bonheur15/go-db-manager/database"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestDeletionProtection(t *testing.T) {
+	engine := newMemoryEngine(map[string][]string{"shop": nil})
+	m := newRecycleManager(t, engine, time.Hour)
+	ctx := context.Background()
+
+	if err := m.SetDeletionProtection(ctx, "postgres", DeletionProtectionOptions{DatabaseName: "shop", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.DeleteDatabase(ctx, "postgres", database.DeleteOptions{DatabaseName: "shop", Permanent: true}); database.CodeOf(err) != database.CodeConflict {
+		t.Errorf("deleting a protected database = %v, want conflict", err)
+	}
+	rename := database.RenameOptions{OldDatabaseName: "shop", NewDatabaseName: "store"}
+	if _, err := m.RenameDatabase(ctx, "postgres", rename); database.CodeOf(err) != database.CodeConflict {
+		t.Errorf("renaming a protected database = %v, want conflict", err)
+	}
+	if _, ok := engine.databases["shop"]; !ok {
+		t.Fatal("the protected database was changed")
+	}
+
+	if err := m.SetDeletionProtection(ctx, "postgres", DeletionProtectionOptions{DatabaseName: "shop"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.DeleteDatabase(ctx, "postgres", database.DeleteOptions{DatabaseName: "shop", Permanent: true}); err != nil {
+		t.Errorf("deleting an unprotected database = %v", err)
+	}
+}
+
+func TestDeletionProtectionFailsClosed(t *testing.T) {
+	engine := newMemoryEngine(map[string][]string{"shop": nil})
+	m := newRecycleManager(t, engine, time.Hour)
+	ctx := context.Background()
+
+	// A record that cannot be decoded may well be protecting the database.
+	err := m.config.Catalog.DB().Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("databases")).Put(catalog.Key("postgres", "main", "shop"), []byte("{"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.DeleteDatabase(ctx, "postgres", database.DeleteOptions{DatabaseName: "shop", Permanent: true}); database.CodeOf(err) != database.CodeInternal {
+		t.Errorf("deleting with an unreadable record = %v, want internal", err)
+	}
+	rename := database.RenameOptions{OldDatabaseName: "shop", NewDatabaseName: "store"}
+	if _, err := m.RenameDatabase(ctx, "postgres", rename); database.CodeOf(err) != database.CodeInternal {
+		t.Errorf("renaming with an unreadable record = %v, want internal", err)
+	}
+	if _, ok := engine.databases["shop"]; !ok {
+		t.Error("the database was changed although its record could not be read")
+	}
+}
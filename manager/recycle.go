@@ -0,0 +1,273 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bonheur15/go-db-manager/catalog"
+	"github.com/bonheur15/go-db-manager/database"
+	"github.com/rs/zerolog/log"
+)
+
+// DeleteResult reports what became of a deleted database.
+type DeleteResult struct {
+	DatabaseName string `json:"database_name"`
+	// RecycledAs is the name the database was moved to in the recycle bin;
+	// it is empty when the database was dropped.
+	RecycledAs string     `json:"recycled_as,omitempty"`
+	PurgeAt    *time.Time `json:"purge_at,omitempty"`
+	// PasswordsReset means recycling gave the users of the database new
+	// passwords, which nobody is told: a restore gives them new ones again
+	// and the old credentials never work again.
+	PasswordsReset bool `json:"passwords_reset,omitempty"`
+}
+
+// recycledName is the name a database is kept under in the recycle bin.
+func recycledName(dbName string, deletedAt time.Time) string {
+	return fmt.Sprintf("%s_deleted_%d", dbName, deletedAt.Unix())
+}
+
+// recycle moves a database to the recycle bin: it is renamed out of the
+// way, its users are locked where the engine supports it, and the catalog
+// records when it is to be purged. Where a rename would copy the data, the
+// database has to be deleted permanently instead.
+func (m *Manager) recycle(ctx context.Context, engine database.Engine, opts database.DeleteOptions) (*DeleteResult, error) {
+	dbName := opts.DatabaseName
+	users := opts.Users
+	if users == nil {
+		info, err := engine.Describe(ctx, dbName)
+		if err != nil {
+			return nil, err
+		}
+		users = info.Users
+	}
+
+	now := time.Now().UTC()
+	purgeAt := now.Add(m.config.RecycleRetention)
+	name := recycledName(dbName, now)
+	if problem := database.NameProblem(engine.Name(), name); problem != "" {
+		return nil, &database.OpError{
+			Action: engine.Name() + "-recycle-database",
+			Code:   database.CodeInvalidArgument,
+			Err:    fmt.Errorf("database %s cannot be recycled as %s, which %s; delete it permanently instead", dbName, name, problem),
+		}
+	}
+	var capabilities database.Capabilities
+	if reporter, ok := engine.(database.CapabilityReporter); ok {
+		var err error
+		if capabilities, err = reporter.Capabilities(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if capabilities.RenameCopiesData {
+		return nil, &database.OpError{
+			Action: engine.Name() + "-recycle-database",
+			Code:   database.CodeInvalidArgument,
+			Err:    fmt.Errorf("database %s cannot be recycled on this server without copying all its data; delete it permanently instead", dbName),
+		}
+	}
+	renamed, err := engine.Rename(ctx, database.RenameOptions{OldDatabaseName: dbName, NewDatabaseName: name, Force: true})
+	if err != nil {
+		return nil, err
+	}
+
+	if locker, ok := engine.(database.UserLocker); ok && len(users) > 0 {
+		if err := locker.LockUsers(ctx, users); err != nil {
+			m.undoRename(ctx, engine, name, dbName)
+			return nil, err
+		}
+	}
+
+	err = m.config.Catalog.Rename(engine.Name(), engine.Target(), dbName, name, func(r *catalog.Record) {
+		if r.CreatedAt.IsZero() {
+			r.CreatedAt = now
+		}
+		if r.Users == nil {
+			r.Users = users
+		}
+		r.Status = catalog.StatusRecycled
+		r.OriginalName = dbName
+		r.DeletedAt = &now
+		r.PurgeAt = &purgeAt
+		r.LastOperation = "delete"
+		r.UpdatedAt = now
+	})
+	if err != nil {
+		// Without its record the database would never be purged or
+		// restored, so the delete is undone.
+		if locker, ok := engine.(database.UserLocker); ok && len(users) > 0 {
+			if err := locker.UnlockUsers(context.WithoutCancel(ctx), users); err != nil {
+				log.Error().Err(err).Str("database", dbName).Msg("unlocking users after a failed delete failed")
+			}
+		}
+		m.undoRename(ctx, engine, name, dbName)
+		return nil, err
+	}
+	return &DeleteResult{DatabaseName: dbName, RecycledAs: name, PurgeAt: &purgeAt, PasswordsReset: renamed.PasswordsReset}, nil
+}
+
+func (m *Manager) undoRename(ctx context.Context, engine database.Engine, from, to string) {
+	_, err := engine.Rename(context.WithoutCancel(ctx), database.RenameOptions{OldDatabaseName: from, NewDatabaseName: to, Force: true})
+	if err != nil {
+		log.Error().Err(err).
+			Str("engine", engine.Name()).
+			Str("target", engine.Target()).
+			Str("database", from).
+			Msg("moving a recycled database back failed")
+	}
+}
+
+// RestoreDatabase brings the most recently deleted database called
+// opts.DatabaseName back from the recycle bin, with its users unlocked.
+// Engines that recreate users on renames cannot bring back their original
+// passwords: the result then has PasswordsReset set and lists the users
+// with their new passwords.
+func (m *Manager) RestoreDatabase(ctx context.Context, ref string, opts database.DatabaseOptions) (*database.RenameResult, error) {
+	engine, err := m.engine(ref, opts)
+	if err != nil {
+		return nil, err
+	}
+	record, err := m.recycledRecord(engine, opts.DatabaseName)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := m.lock(ctx, engine, opts.DatabaseName, record.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	result, err := engine.Rename(ctx, database.RenameOptions{OldDatabaseName: record.Name, NewDatabaseName: opts.DatabaseName, Force: true})
+	if err != nil {
+		return nil, err
+	}
+	if locker, ok := engine.(database.UserLocker); ok && len(record.Users) > 0 {
+		if err := locker.UnlockUsers(ctx, record.Users); err != nil {
+			m.undoRename(ctx, engine, opts.DatabaseName, record.Name)
+			return nil, err
+		}
+	}
+
+	now := time.Now().UTC()
+	err = m.config.Catalog.Rename(engine.Name(), engine.Target(), record.Name, opts.DatabaseName, func(r *catalog.Record) {
+		r.Status = catalog.StatusActive
+		r.OriginalName = ""
+		r.DeletedAt = nil
+		r.PurgeAt = nil
+		r.LastOperation = "restore"
+		r.UpdatedAt = now
+	})
+	if err != nil {
+		logCatalogError(err, engine, opts.DatabaseName, "restore")
+	}
+	return result, nil
+}
+
+// recycledRecord finds the most recently deleted database called dbName
+// in the recycle bin of engine's target.
+func (m *Manager) recycledRecord(engine database.Engine, dbName string) (*catalog.Record, error) {
+	notFound := &database.OpError{
+		Action: engine.Name() + "-restore-database",
+		Code:   database.CodeNotFound,
+		Err:    fmt.Errorf("database %s is not in the recycle bin", dbName),
+	}
+	if m.config.Catalog == nil {
+		return nil, notFound
+	}
+	records, err := m.config.Catalog.List(catalog.Filter{
+		Engine: engine.Name(),
+		Target: engine.Target(),
+		Status: catalog.StatusRecycled,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var latest *catalog.Record
+	for i := range records {
+		record := &records[i]
+		if record.OriginalName != dbName {
+			continue
+		}
+		if latest == nil || record.DeletedAt.After(*latest.DeletedAt) {
+			latest = record
+		}
+	}
+	if latest == nil {
+		return nil, notFound
+	}
+	return latest, nil
+}
+
+// PurgeRecycled drops the recycled databases whose retention has run out
+// and returns how many were dropped. A database that cannot be dropped is
+// logged and retried on the next run.
+func (m *Manager) PurgeRecycled(ctx context.Context) (int, error) {
+	if m.config.Catalog == nil {
+		return 0, nil
+	}
+	records, err := m.config.Catalog.List(catalog.Filter{Status: catalog.StatusRecycled})
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	purged := 0
+	for _, record := range records {
+		if record.PurgeAt == nil || record.PurgeAt.After(now) {
+			continue
+		}
+		if err := m.purge(ctx, record); err != nil {
+			log.Error().Err(err).
+				Str("engine", record.Engine).
+				Str("target", record.Target).
+				Str("database", record.Name).
+				Msg("purging recycled database failed")
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+func (m *Manager) purge(ctx context.Context, record catalog.Record) error {
+	engine, err := m.registry.Get(record.Engine + "/" + record.Target)
+	if err != nil {
+		return err
+	}
+	unlock, err := m.lock(ctx, engine, record.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = engine.Delete(ctx, database.DeleteOptions{DatabaseName: record.Name, Users: record.Users})
+	// A database dropped by hand is as good as purged.
+	if err != nil && database.CodeOf(err) != database.CodeNotFound {
+		return err
+	}
+	m.record(engine, record.Name, "purge", func(r *catalog.Record) {
+		r.Status = catalog.StatusDeleted
+		r.PurgeAt = nil
+	})
+	return nil
+}
+
+// RunPurger purges expired recycled databases every interval until ctx is
+// done.
+func (m *Manager) RunPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if purged, err := m.PurgeRecycled(ctx); err != nil {
+				log.Error().Err(err).Msg("purging recycled databases failed")
+			} else if purged > 0 {
+				log.Info().Int("purged", purged).Msg("purged recycled databases")
+			}
+		}
+	}
+}
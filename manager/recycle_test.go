@@ -0,0 +1,287 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bonheur15/go-db-manager/catalog"
+	"github.com/bonheur15/go-db-manager/database"
+)
+
+// memoryEngine keeps databases and their users in memory, and locks users
+// like the engines that support it.
+type memoryEngine struct {
+	database.Engine
+	databases    map[string][]string
+	locked       map[string]bool
+	capabilities *database.Capabilities
+}
+
+func newMemoryEngine(databases map[string][]string) *memoryEngine {
+	return &memoryEngine{databases: databases, locked: make(map[string]bool)}
+}
+
+func (e *memoryEngine) Name() string                 { return "postgres" }
+func (e *memoryEngine) Target() string               { return "main" }
+func (e *memoryEngine) IsSystemDatabase(string) bool { return false }
+
+func (e *memoryEngine) notFound(action, name string) error {
+	return &database.OpError{Action: action, Code: database.CodeNotFound, Err: fmt.Errorf("database %s does not exist", name)}
+}
+
+func (e *memoryEngine) Describe(ctx context.Context, dbName string) (*database.DatabaseInfo, error) {
+	users, ok := e.databases[dbName]
+	if !ok {
+		return nil, e.notFound("describe", dbName)
+	}
+	return &database.DatabaseInfo{DatabaseName: dbName, Users: users}, nil
+}
+
+func (e *memoryEngine) Rename(ctx context.Context, opts database.RenameOptions) (*database.RenameResult, error) {
+	users, ok := e.databases[opts.OldDatabaseName]
+	if !ok {
+		return nil, e.notFound("rename", opts.OldDatabaseName)
+	}
+	if _, exists := e.databases[opts.NewDatabaseName]; exists {
+		return nil, &database.OpError{Action: "rename", Code: database.CodeConflict, Err: fmt.Errorf("database %s exists", opts.NewDatabaseName)}
+	}
+	delete(e.databases, opts.OldDatabaseName)
+	e.databases[opts.NewDatabaseName] = users
+	result := &database.RenameResult{OldDatabaseName: opts.OldDatabaseName, NewDatabaseName: opts.NewDatabaseName}
+	if e.capabilities != nil && e.capabilities.RenameResetsPasswords {
+		for _, user := range users {
+			result.Users = append(result.Users, database.Credentials{Username: user, Password: "new", DatabaseName: opts.NewDatabaseName})
+		}
+		result.PasswordsReset = len(users) > 0
+	}
+	return result, nil
+}
+
+func (e *memoryEngine) Delete(ctx context.Context, opts database.DeleteOptions) error {
+	if _, ok := e.databases[opts.DatabaseName]; !ok {
+		return e.notFound("delete", opts.DatabaseName)
+	}
+	delete(e.databases, opts.DatabaseName)
+	return nil
+}
+
+func (e *memoryEngine) LockUsers(ctx context.Context, usernames []string) error {
+	for _, username := range usernames {
+		e.locked[username] = true
+	}
+	return nil
+}
+
+func (e *memoryEngine) UnlockUsers(ctx context.Context, usernames []string) error {
+	for _, username := range usernames {
+		delete(e.locked, username)
+	}
+	return nil
+}
+
+// capabilityEngine adds Capabilities to a memoryEngine.
+type capabilityEngine struct {
+	*memoryEngine
+}
+
+func (e capabilityEngine) Capabilities(ctx context.Context) (database.Capabilities, error) {
+	return *e.capabilities, nil
+}
+
+func newRecycleManager(t *testing.T, engine database.Engine, retention time.Duration) *Manager {
+	t.Helper()
+	store, err := catalog.Open(filepath.Join(t.TempDir(), "catalog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	registry := database.NewRegistry()
+	if err := registry.Register(engine, database.TargetConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	return New(registry, Config{Catalog: store, RecycleRetention: retention})
+}
+
+func databaseNames(databases map[string][]string) []string {
+	var names []string
+	for name := range databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRecycledName(t *testing.T) {
+	deletedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if got, want := recycledName("shop", deletedAt), "shop_deleted_1714564800"; got != want {
+		t.Errorf("recycledName = %s, want %s", got, want)
+	}
+	// The name depends on the instant, not on the time zone.
+	local := deletedAt.In(time.FixedZone("UTC+2", 2*60*60))
+	if got := recycledName("shop", local); got != "shop_deleted_1714564800" {
+		t.Errorf("recycledName in another zone = %s", got)
+	}
+}
+
+func TestDeleteRecyclesAndRestore(t *testing.T) {
+	engine := newMemoryEngine(map[string][]string{"shop": {"alice"}})
+	m := newRecycleManager(t, engine, time.Hour)
+	ctx := context.Background()
+
+	result, err := m.DeleteDatabase(ctx, "postgres", database.DeleteOptions{DatabaseName: "shop"})
+	if err != nil {
+		t.Fatalf("DeleteDatabase = %v", err)
+	}
+	if !strings.HasPrefix(result.RecycledAs, "shop_deleted_") || result.PurgeAt == nil {
+		t.Fatalf("DeleteDatabase = %+v, want the tombstone name and purge time", result)
+	}
+	if got := databaseNames(engine.databases); !reflect.DeepEqual(got, []string{result.RecycledAs}) {
+		t.Errorf("databases after delete = %v, want only %s", got, result.RecycledAs)
+	}
+	if !engine.locked["alice"] {
+		t.Error("the users of the recycled database were not locked")
+	}
+	record, err := m.config.Catalog.Get("postgres", "main", result.RecycledAs)
+	if err != nil {
+		t.Fatalf("the tombstone is not in the catalog: %v", err)
+	}
+	if record.Status != catalog.StatusRecycled || record.OriginalName != "shop" || !reflect.DeepEqual(record.Users, []string{"alice"}) {
+		t.Errorf("tombstone record = %+v", record)
+	}
+
+	restored, err := m.RestoreDatabase(ctx, "postgres", database.DatabaseOptions{DatabaseName: "shop"})
+	if err != nil {
+		t.Fatalf("RestoreDatabase = %v", err)
+	}
+	if restored.NewDatabaseName != "shop" || restored.PasswordsReset {
+		t.Errorf("RestoreDatabase = %+v", restored)
+	}
+	if got := databaseNames(engine.databases); !reflect.DeepEqual(got, []string{"shop"}) {
+		t.Errorf("databases after restore = %v, want [shop]", got)
+	}
+	if engine.locked["alice"] {
+		t.Error("the users of the restored database are still locked")
+	}
+	record, err = m.config.Catalog.Get("postgres", "main", "shop")
+	if err != nil || record.Status != catalog.StatusActive || record.PurgeAt != nil {
+		t.Errorf("restored record = %+v, %v", record, err)
+	}
+	if _, err := m.RestoreDatabase(ctx, "postgres", database.DatabaseOptions{DatabaseName: "shop"}); database.CodeOf(err) != database.CodeNotFound {
+		t.Errorf("restoring twice = %v, want not_found", err)
+	}
+}
+
+func TestRestoreConflict(t *testing.T) {
+	engine := newMemoryEngine(map[string][]string{"shop": nil})
+	m := newRecycleManager(t, engine, time.Hour)
+	ctx := context.Background()
+
+	result, err := m.DeleteDatabase(ctx, "postgres", database.DeleteOptions{DatabaseName: "shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.databases["shop"] = nil
+	if _, err := m.RestoreDatabase(ctx, "postgres", database.DatabaseOptions{DatabaseName: "shop"}); database.CodeOf(err) != database.CodeConflict {
+		t.Errorf("RestoreDatabase over a new database = %v, want conflict", err)
+	}
+	if _, ok := engine.databases[result.RecycledAs]; !ok {
+		t.Error("the tombstone was lost by a failed restore")
+	}
+}
+
+func TestDeletePermanent(t *testing.T) {
+	engine := newMemoryEngine(map[string][]string{"shop": nil})
+	m := newRecycleManager(t, engine, time.Hour)
+
+	result, err := m.DeleteDatabase(context.Background(), "postgres", database.DeleteOptions{DatabaseName: "shop", Permanent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RecycledAs != "" || len(engine.databases) != 0 {
+		t.Errorf("permanent delete = %+v, databases %v, want the database dropped", result, engine.databases)
+	}
+}
+
+func TestPurgeRecycled(t *testing.T) {
+	engine := newMemoryEngine(map[string][]string{"shop": nil, "blog": nil})
+	m := newRecycleManager(t, engine, time.Hour)
+	ctx := context.Background()
+
+	expired, err := m.DeleteDatabase(ctx, "postgres", database.DeleteOptions{DatabaseName: "shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := m.DeleteDatabase(ctx, "postgres", database.DeleteOptions{DatabaseName: "blog"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.config.Catalog.Update("postgres", "main", expired.RecycledAs, func(r *catalog.Record) error {
+		past := time.Now().Add(-time.Minute)
+		r.PurgeAt = &past
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := m.PurgeRecycled(ctx)
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeRecycled = %d, %v, want 1", purged, err)
+	}
+	if got := databaseNames(engine.databases); !reflect.DeepEqual(got, []string{kept.RecycledAs}) {
+		t.Errorf("databases after purge = %v, want only %s", got, kept.RecycledAs)
+	}
+	record, err := m.config.Catalog.Get("postgres", "main", expired.RecycledAs)
+	if err != nil || record.Status != catalog.StatusDeleted {
+		t.Errorf("purged record = %+v, %v, want status deleted", record, err)
+	}
+
+	// A tombstone dropped by hand counts as purged.
+	delete(engine.databases, kept.RecycledAs)
+	m.config.Catalog.Update("postgres", "main", kept.RecycledAs, func(r *catalog.Record) error {
+		past := time.Now().Add(-time.Minute)
+		r.PurgeAt = &past
+		return nil
+	})
+	if purged, err := m.PurgeRecycled(ctx); err != nil || purged != 1 {
+		t.Errorf("PurgeRecycled of a missing tombstone = %d, %v, want 1", purged, err)
+	}
+}
+
+func TestRecycleCapabilities(t *testing.T) {
+	t.Run("rename copies data", func(t *testing.T) {
+		engine := capabilityEngine{newMemoryEngine(map[string][]string{"shop": {"alice"}})}
+		engine.capabilities = &database.Capabilities{RenameCopiesData: true}
+		m := newRecycleManager(t, engine, time.Hour)
+
+		_, err := m.DeleteDatabase(context.Background(), "postgres", database.DeleteOptions{DatabaseName: "shop"})
+		if database.CodeOf(err) != database.CodeInvalidArgument {
+			t.Errorf("DeleteDatabase = %v, want invalid_argument", err)
+		}
+		if got := databaseNames(engine.databases); !reflect.DeepEqual(got, []string{"shop"}) {
+			t.Errorf("databases = %v, want shop left alone", got)
+		}
+	})
+
+	t.Run("rename resets passwords", func(t *testing.T) {
+		engine := capabilityEngine{newMemoryEngine(map[string][]string{"shop": {"alice"}})}
+		engine.capabilities = &database.Capabilities{RenameResetsPasswords: true}
+		m := newRecycleManager(t, engine, time.Hour)
+		ctx := context.Background()
+
+		result, err := m.DeleteDatabase(ctx, "postgres", database.DeleteOptions{DatabaseName: "shop"})
+		if err != nil || !result.PasswordsReset {
+			t.Fatalf("DeleteDatabase = %+v, %v, want PasswordsReset", result, err)
+		}
+		restored, err := m.RestoreDatabase(ctx, "postgres", database.DatabaseOptions{DatabaseName: "shop"})
+		if err != nil || !restored.PasswordsReset || len(restored.Users) != 1 {
+			t.Errorf("RestoreDatabase = %+v, %v, want the users with their new passwords", restored, err)
+		}
+	})
+}
@@ -0,0 +1,292 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bonheur15/go-db-manager/catalog"
+	"github.com/bonheur15/go-db-manager/database"
+	"github.com/rs/zerolog/log"
+)
+
+// RotateOptions starts a credential rotation for a user of a database.
+type RotateOptions struct {
+	DatabaseName string `json:"database_name" validate:"required,dbname"`
+	Username     string `json:"username" validate:"required,username"`
+	// GraceSeconds is how long the old credential keeps working, by
+	// default Config.RotationGrace. Zero keeps it until the rotation is
+	// confirmed.
+	GraceSeconds *int `json:"grace_seconds" validate:"omitempty,min=0"`
+}
+
+// credentialRotator resolves ref to an engine that rotates credentials.
+// Rotations are tracked in the catalog, so they need one.
+func (m *Manager) credentialRotator(ref string, opts interface{}, action string) (database.Engine, database.CredentialRotator, error) {
+	engine, err := m.engine(ref, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	rotator, err := m.rotator(engine, action)
+	if err != nil {
+		return nil, nil, err
+	}
+	return engine, rotator, nil
+}
+
+func (m *Manager) rotator(engine database.Engine, action string) (database.CredentialRotator, error) {
+	rotator, ok := engine.(database.CredentialRotator)
+	if !ok {
+		return nil, &database.OpError{Action: engine.Name() + "-" + action, Code: database.CodeInvalidArgument, Err: fmt.Errorf("engine %s does not rotate credentials", engine.Name())}
+	}
+	if m.config.Catalog == nil {
+		return nil, &database.OpError{Action: engine.Name() + "-" + action, Code: database.CodeInvalidArgument, Err: errors.New("credential rotation needs the catalog")}
+	}
+	return rotator, nil
+}
+
+// RotateCredentials gives a user of a database a second credential. The
+// old one keeps working until the grace period runs out or the rotation is
+// confirmed, so that applications can switch over in between.
+func (m *Manager) RotateCredentials(ctx context.Context, ref string, opts RotateOptions) (*database.Rotation, error) {
+	engine, rotator, err := m.credentialRotator(ref, opts, "rotate-credentials")
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := m.lock(ctx, engine, opts.DatabaseName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if record, err := m.config.Catalog.Get(engine.Name(), engine.Target(), opts.DatabaseName); err == nil && findRotation(record.Rotations, opts.Username) >= 0 {
+		return nil, &database.OpError{
+			Action: engine.Name() + "-rotate-credentials",
+			Code:   database.CodeConflict,
+			Err:    fmt.Errorf("user %s already has a rotation in progress; confirm it first", opts.Username),
+		}
+	}
+
+	rotation, err := rotator.StartRotation(ctx, database.UserOptions{DatabaseName: opts.DatabaseName, Username: opts.Username})
+	if err != nil {
+		return nil, err
+	}
+	grace := m.config.RotationGrace
+	if opts.GraceSeconds != nil {
+		grace = time.Duration(*opts.GraceSeconds) * time.Second
+	}
+	now := time.Now().UTC()
+	if grace > 0 {
+		retireAt := now.Add(grace)
+		rotation.RetireAt = &retireAt
+	}
+
+	var current []string
+	if rotation.ReplacesUser {
+		current = m.serverUsers(ctx, engine, opts.DatabaseName)
+	}
+	err = m.record(engine, opts.DatabaseName, "rotate-credentials", func(r *catalog.Record) {
+		if rotation.ReplacesUser {
+			if r.Users == nil && current != nil {
+				r.Users = current
+			} else {
+				r.Users = append(r.Users, rotation.NewUsername)
+			}
+		}
+		r.Rotations = append(r.Rotations, catalog.Rotation{
+			Username:        rotation.Username,
+			NewUsername:     rotation.NewUsername,
+			RetiredUsername: rotation.RetiredUsername,
+			ReplacesUser:    rotation.ReplacesUser,
+			StartedAt:       now,
+			RetireAt:        rotation.RetireAt,
+		})
+	})
+	if err != nil {
+		// Without its record the old credential would never be retired.
+		return nil, err
+	}
+	return rotation, nil
+}
+
+// recordReplacement records the users a reset with a grace period left in
+// place as rotations replaced by the new user, to be retired after grace.
+func (m *Manager) recordReplacement(ctx context.Context, engine database.Engine, dbName string, creds *database.Credentials, grace time.Duration) error {
+	now := time.Now().UTC()
+	retireAt := now.Add(grace)
+	creds.RetireAt = &retireAt
+
+	current := m.serverUsers(ctx, engine, dbName)
+	return m.record(engine, dbName, "reset-credentials", func(r *catalog.Record) {
+		if r.Users == nil && current != nil {
+			r.Users = current
+		} else {
+			r.Users = append(r.Users, creds.Username)
+		}
+		for _, old := range creds.Replaced {
+			r.Rotations = append(removeRotation(r.Rotations, old), catalog.Rotation{
+				Username:        old,
+				NewUsername:     creds.Username,
+				RetiredUsername: old,
+				ReplacesUser:    true,
+				StartedAt:       now,
+				RetireAt:        &retireAt,
+			})
+		}
+	})
+}
+
+// ConfirmRotation retires the old credential of a user's rotation right
+// away, once the applications have switched to the new one.
+func (m *Manager) ConfirmRotation(ctx context.Context, ref string, opts database.UserOptions) (*database.Rotation, error) {
+	engine, rotator, err := m.credentialRotator(ref, opts, "confirm-rotation")
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := m.lock(ctx, engine, opts.DatabaseName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return m.finishRotation(ctx, engine, rotator, opts.DatabaseName, opts.Username)
+}
+
+// finishRotation retires the old credential of username's rotation and
+// removes the rotation from the catalog. The caller holds the lock of
+// dbName.
+func (m *Manager) finishRotation(ctx context.Context, engine database.Engine, rotator database.CredentialRotator, dbName, username string) (*database.Rotation, error) {
+	record, err := m.config.Catalog.Get(engine.Name(), engine.Target(), dbName)
+	if err != nil && !errors.Is(err, catalog.ErrNotFound) {
+		return nil, err
+	}
+	i := -1
+	if record != nil {
+		i = findRotation(record.Rotations, username)
+	}
+	if i < 0 {
+		return nil, &database.OpError{
+			Action: engine.Name() + "-confirm-rotation",
+			Code:   database.CodeNotFound,
+			Err:    fmt.Errorf("user %s of database %s has no rotation in progress", username, dbName),
+		}
+	}
+
+	pending := record.Rotations[i]
+	rotation := database.Rotation{
+		DatabaseName:    dbName,
+		Username:        pending.Username,
+		NewUsername:     pending.NewUsername,
+		RetiredUsername: pending.RetiredUsername,
+		ReplacesUser:    pending.ReplacesUser,
+		RetireAt:        pending.RetireAt,
+	}
+	if err := rotator.FinishRotation(ctx, rotation); err != nil {
+		return nil, err
+	}
+	m.record(engine, dbName, "finish-rotation", func(r *catalog.Record) {
+		r.Rotations = removeRotation(r.Rotations, username)
+		if rotation.ReplacesUser {
+			r.Users = removeUser(r.Users, rotation.RetiredUsername)
+		}
+	})
+	return &rotation, nil
+}
+
+// FinishRotations retires the old credentials whose grace period has run
+// out and returns how many were retired. A rotation that cannot be
+// finished is logged and retried on the next run.
+func (m *Manager) FinishRotations(ctx context.Context) (int, error) {
+	if m.config.Catalog == nil {
+		return 0, nil
+	}
+	records, err := m.config.Catalog.List(catalog.Filter{Status: catalog.StatusActive})
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	finished := 0
+	for _, record := range records {
+		for _, rotation := range record.Rotations {
+			if rotation.RetireAt == nil || rotation.RetireAt.After(now) {
+				continue
+			}
+			if err := m.finishDueRotation(ctx, record, rotation.Username); err != nil {
+				log.Error().Err(err).
+					Str("engine", record.Engine).
+					Str("target", record.Target).
+					Str("database", record.Name).
+					Str("username", rotation.Username).
+					Msg("finishing credential rotation failed")
+				continue
+			}
+			finished++
+		}
+	}
+	return finished, nil
+}
+
+func (m *Manager) finishDueRotation(ctx context.Context, record catalog.Record, username string) error {
+	engine, err := m.registry.Get(record.Engine + "/" + record.Target)
+	if err != nil {
+		return err
+	}
+	rotator, ok := engine.(database.CredentialRotator)
+	if !ok {
+		return fmt.Errorf("engine %s does not rotate credentials", engine.Name())
+	}
+	unlock, err := m.lock(ctx, engine, record.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// A rotation confirmed since the catalog was read is already done.
+	_, err = m.finishRotation(ctx, engine, rotator, record.Name, username)
+	if database.CodeOf(err) == database.CodeNotFound {
+		return nil
+	}
+	return err
+}
+
+// RunRotations finishes the rotations whose grace period has run out every
+// interval until ctx is done.
+func (m *Manager) RunRotations(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if finished, err := m.FinishRotations(ctx); err != nil {
+				log.Error().Err(err).Msg("finishing credential rotations failed")
+			} else if finished > 0 {
+				log.Info().Int("finished", finished).Msg("finished credential rotations")
+			}
+		}
+	}
+}
+
+func findRotation(rotations []catalog.Rotation, username string) int {
+	for i, rotation := range rotations {
+		if rotation.Username == username {
+			return i
+		}
+	}
+	return -1
+}
+
+func removeRotation(rotations []catalog.Rotation, username string) []catalog.Rotation {
+	var kept []catalog.Rotation
+	for _, rotation := range rotations {
+		if rotation.Username != username {
+			kept = append(kept, rotation)
+		}
+	}
+	return kept
+}
+
+func (o RotateOptions) DatabaseNames() []string { return []string{o.DatabaseName} }
@@ -0,0 +1,140 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bonheur15/go-db-manager/catalog"
+	"github.com/bonheur15/go-db-manager/database"
+)
+
+// userManager resolves ref to an engine that manages users one by one.
+func (m *Manager) userManager(ref string, opts interface{}, action string) (database.Engine, database.UserManager, error) {
+	engine, err := m.engine(ref, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	users, ok := engine.(database.UserManager)
+	if !ok {
+		return nil, nil, &database.OpError{Action: engine.Name() + "-" + action, Code: database.CodeInvalidArgument, Err: fmt.Errorf("engine %s does not manage users", engine.Name())}
+	}
+	return engine, users, nil
+}
+
+// Users lists the users of a database as the server reports them.
+func (m *Manager) Users(ctx context.Context, ref string, opts database.DatabaseOptions) ([]database.DatabaseUser, error) {
+	_, users, err := m.userManager(ref, opts, "list-users")
+	if err != nil {
+		return nil, err
+	}
+	return users.Users(ctx, opts.DatabaseName)
+}
+
+// AddUser creates another user of a database and records it in the
+// catalog, so that it is dropped along with the database.
+func (m *Manager) AddUser(ctx context.Context, ref string, opts database.UserOptions) (*database.Credentials, error) {
+	engine, users, err := m.userManager(ref, opts, "add-user")
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := m.lock(ctx, engine, opts.DatabaseName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	creds, err := users.AddUser(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	creds.Target = engine.Target()
+
+	current := m.serverUsers(ctx, engine, opts.DatabaseName)
+	m.record(engine, opts.DatabaseName, "add-user", func(r *catalog.Record) {
+		if r.Users == nil && current != nil {
+			r.Users = current
+			return
+		}
+		r.Users = append(r.Users, creds.Username)
+	})
+	return creds, nil
+}
+
+// serverUsers lists the users the server reports for a database the
+// catalog knows no users of, which is about to get another one: the
+// catalog must then record them all, or deleting the database would only
+// drop the new user. It returns nil when the catalog knows the users.
+func (m *Manager) serverUsers(ctx context.Context, engine database.Engine, dbName string) []string {
+	users, ok := engine.(database.UserManager)
+	if !ok || m.knownUsers(engine, dbName) != nil {
+		return nil
+	}
+	listed, err := users.Users(ctx, dbName)
+	if err != nil {
+		return nil
+	}
+	var current []string
+	for _, user := range listed {
+		current = append(current, user.Username)
+	}
+	return current
+}
+
+// RemoveUser drops one user of a database.
+func (m *Manager) RemoveUser(ctx context.Context, ref string, opts database.UserOptions) error {
+	engine, users, err := m.userManager(ref, opts, "remove-user")
+	if err != nil {
+		return err
+	}
+	unlock, err := m.lock(ctx, engine, opts.DatabaseName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := users.RemoveUser(ctx, opts); err != nil {
+		return err
+	}
+	m.record(engine, opts.DatabaseName, "remove-user", func(r *catalog.Record) {
+		r.Users = removeUser(r.Users, opts.Username)
+		r.Rotations = removeRotation(r.Rotations, opts.Username)
+	})
+	return nil
+}
+
+// ResetPassword gives one user of a database a new password.
+func (m *Manager) ResetPassword(ctx context.Context, ref string, opts database.UserOptions) (*database.Credentials, error) {
+	engine, users, err := m.userManager(ref, opts, "reset-password")
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := m.lock(ctx, engine, opts.DatabaseName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	creds, err := users.ResetPassword(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	creds.Target = engine.Target()
+	m.record(engine, opts.DatabaseName, "reset-password", func(r *catalog.Record) {})
+	return creds, nil
+}
+
+// removeUser returns usernames without username. Unknown users stay
+// unknown: a nil list is returned as nil, so that deletes still look the
+// users up on the server.
+func removeUser(usernames []string, username string) []string {
+	if usernames == nil {
+		return nil
+	}
+	kept := make([]string, 0, len(usernames))
+	for _, name := range usernames {
+		if name != username {
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,28 @@
+package manager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRemoveUser(t *testing.T) {
+	tests := []struct {
+		name      string
+		usernames []string
+		username  string
+		want      []string
+	}{
+		{"unknown users stay unknown", nil, "alice", nil},
+		{"removes the user", []string{"alice", "bob"}, "alice", []string{"bob"}},
+		{"removes every occurrence", []string{"alice", "bob", "alice"}, "alice", []string{"bob"}},
+		{"keeps the others", []string{"bob"}, "alice", []string{"bob"}},
+		{"last user leaves a known empty list", []string{"alice"}, "alice", []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := removeUser(test.usernames, test.username); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("removeUser(%v, %s) = %#v, want %#v", test.usernames, test.username, got, test.want)
+			}
+		})
+	}
+}
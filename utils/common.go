@@ -2,8 +2,10 @@ package utils
 
 import (
 	"crypto/rand"
+	"encoding/json"
 	"fmt"
 	"math/big"
+	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -11,8 +13,18 @@ import (
 )
 
 func SuccessResponse(c *gin.Context, data map[string]interface{}, startTime int64, action, message string) {
+	successResponse(c, http.StatusOK, data, startTime, action, message)
+}
+
+// AcceptedResponse is SuccessResponse for requests that were accepted to
+// run in the background.
+func AcceptedResponse(c *gin.Context, data map[string]interface{}, startTime int64, action, message string) {
+	successResponse(c, http.StatusAccepted, data, startTime, action, message)
+}
+
+func successResponse(c *gin.Context, status int, data map[string]interface{}, startTime int64, action, message string) {
 	log.Info().Str("action", action).Msg(message)
-	c.JSON(200, gin.H{
+	c.JSON(status, gin.H{
 		"data":            data,
 		"error":           false,
 		"action":          action,
@@ -22,18 +34,42 @@ func SuccessResponse(c *gin.Context, data map[string]interface{}, startTime int6
 	})
 }
 
-func ErrorResponse(c *gin.Context, err error, startTime int64, action string) {
-	log.Error().Err(err).Str("action", action).Msg(err.Error())
-	c.JSON(400, gin.H{
+// ErrorResponse writes the standard error envelope. code is the stable
+// error code clients should match on instead of the message; data is
+// usually nil.
+func ErrorResponse(c *gin.Context, status int, code string, err error, data map[string]interface{}, startTime int64, action string) {
+	log.Error().Err(err).Str("action", action).Str("code", code).Msg(err.Error())
+	c.JSON(status, gin.H{
 		"error":           true,
+		"code":            code,
 		"message":         err.Error(),
 		"action":          action,
 		"timestamp":       time.Now(),
 		"action_duration": time.Now().UnixMilli() - startTime,
-		"data":            nil,
+		"data":            data,
 	})
 }
 
+// ProblemResponse writes the error as an RFC 7807 problem document, with
+// code, action and the entries of data as extension members.
+func ProblemResponse(c *gin.Context, status int, code string, err error, data map[string]interface{}, action string) {
+	log.Error().Err(err).Str("action", action).Str("code", code).Msg(err.Error())
+	problem := gin.H{
+		"type":     "about:blank",
+		"title":    http.StatusText(status),
+		"status":   status,
+		"detail":   err.Error(),
+		"instance": c.Request.URL.Path,
+		"code":     code,
+		"action":   action,
+	}
+	for key, value := range data {
+		problem[key] = value
+	}
+	body, _ := json.Marshal(problem)
+	c.Data(status, "application/problem+json", body)
+}
+
 const (
 	lowerCharset = "abcdefghijklmnopqrstuvwxyz"
 	mixedCharset = "abcdefghijklmnopqrstuvwxyz0123456789"
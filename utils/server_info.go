@@ -4,6 +4,7 @@ import (
 	"runtime"
 
 	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/disk"
 	"github.com/shirou/gopsutil/host"
 	"github.com/shirou/gopsutil/load"
 	"github.com/shirou/gopsutil/mem"
@@ -14,6 +15,7 @@ type ServerInfo struct {
 	MemInfo      *mem.VirtualMemoryStat `json:"mem_info"`
 	LoadInfo     *load.AvgStat          `json:"load_info"`
 	HostInfo     *host.InfoStat         `json:"host_info"`
+	DiskInfo     *disk.UsageStat        `json:"disk_info"`
 	GoVersion    string                 `json:"go_version"`
 	NumCPU       int                    `json:"num_cpu"`
 	NumGoroutine int                    `json:"num_goroutine"`
@@ -42,11 +44,17 @@ func GetServerInfo() (*ServerInfo, error) {
 		return nil, err
 	}
 
+	diskInfo, err := GetDiskUsage("/")
+	if err != nil {
+		return nil, err
+	}
+
 	serverInfo := &ServerInfo{
 		CPUInfo:      cpuInfo,
 		MemInfo:      memInfo,
 		LoadInfo:     loadInfo,
 		HostInfo:     hostInfo,
+		DiskInfo:     diskInfo,
 		GoVersion:    runtime.Version(),
 		NumCPU:       runtime.NumCPU(),
 		NumGoroutine: runtime.NumGoroutine(),
@@ -56,3 +64,8 @@ func GetServerInfo() (*ServerInfo, error) {
 
 	return serverInfo, nil
 }
+
+// GetDiskUsage reports usage of the filesystem containing path.
+func GetDiskUsage(path string) (*disk.UsageStat, error) {
+	return disk.Usage(path)
+}